@@ -0,0 +1,113 @@
+package taggedurn
+
+import (
+	"container/list"
+	"sync"
+)
+
+// matchCacheKey identifies an (instance, pattern) pair by canonical strings
+type matchCacheKey struct {
+	instance string
+	pattern  string
+}
+
+// matchCacheEntry is an element of the LRU list
+type matchCacheEntry struct {
+	key     matchCacheKey
+	matches bool
+}
+
+// MatchCache memoizes ConformsTo results for (instance, pattern) pairs
+// Entries are keyed by canonical string, so equal URNs share a slot
+// regardless of pointer identity. The cache is bounded: once it holds
+// capacity entries the least recently used one is evicted. Errors (such as a
+// prefix mismatch) are returned but never cached. Since @enum matches depend
+// on the package-wide registry, a RegisterEnum call empties the cache. Safe
+// for concurrent use.
+type MatchCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[matchCacheKey]*list.Element
+	order    *list.List
+	// generation is the enumGeneration the entries were computed under
+	generation uint64
+}
+
+// NewMatchCache creates a cache holding at most capacity results
+// A capacity below 1 is treated as 1.
+func NewMatchCache(capacity int) *MatchCache {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &MatchCache{
+		capacity: capacity,
+		entries:  make(map[matchCacheKey]*list.Element, capacity),
+		order:    list.New(),
+	}
+}
+
+// Get returns whether instance conforms to pattern, computing and caching
+// the result on a miss
+func (m *MatchCache) Get(instance, pattern *TaggedUrn) (bool, error) {
+	if instance == nil || pattern == nil {
+		return false, &TaggedUrnError{
+			Code:    ErrorInvalidFormat,
+			Message: "cannot match nil URNs",
+		}
+	}
+
+	key := matchCacheKey{instance: instance.canonical(), pattern: pattern.canonical()}
+
+	m.mu.Lock()
+	generation := m.refresh()
+	if elem, ok := m.entries[key]; ok {
+		m.order.MoveToFront(elem)
+		matches := elem.Value.(*matchCacheEntry).matches
+		m.mu.Unlock()
+		return matches, nil
+	}
+	m.mu.Unlock()
+
+	// Compute outside the lock; concurrent misses on the same pair are harmless
+	matches, err := instance.ConformsTo(pattern)
+	if err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.refresh() != generation {
+		// The enum registry changed while computing; don't cache the result
+		return matches, nil
+	}
+	if elem, ok := m.entries[key]; ok {
+		m.order.MoveToFront(elem)
+		return matches, nil
+	}
+	m.entries[key] = m.order.PushFront(&matchCacheEntry{key: key, matches: matches})
+	if m.order.Len() > m.capacity {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*matchCacheEntry).key)
+	}
+	return matches, nil
+}
+
+// refresh empties the cache if the enum registry changed since it was filled
+// and returns the current generation. The caller must hold m.mu.
+func (m *MatchCache) refresh() uint64 {
+	generation := enumGeneration.Load()
+	if generation != m.generation {
+		clear(m.entries)
+		m.order.Init()
+		m.generation = generation
+	}
+	return generation
+}
+
+// Len returns the number of cached results
+func (m *MatchCache) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.order.Len()
+}
@@ -0,0 +1,111 @@
+package taggedurn
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchCache(t *testing.T) {
+	cache := NewMatchCache(10)
+	instance, _ := NewTaggedUrnFromString("cap:op=generate;ext=pdf")
+	pattern, _ := NewTaggedUrnFromString("cap:op=generate")
+	mismatch, _ := NewTaggedUrnFromString("cap:op=extract")
+
+	matches, err := cache.Get(instance, pattern)
+	require.NoError(t, err)
+	assert.True(t, matches)
+	assert.Equal(t, 1, cache.Len())
+
+	// Equal URNs with different pointers hit the same slot
+	sameInstance, _ := NewTaggedUrnFromString("cap:ext=pdf;op=generate")
+	matches, err = cache.Get(sameInstance, pattern)
+	require.NoError(t, err)
+	assert.True(t, matches)
+	assert.Equal(t, 1, cache.Len())
+
+	matches, err = cache.Get(instance, mismatch)
+	require.NoError(t, err)
+	assert.False(t, matches)
+	assert.Equal(t, 2, cache.Len())
+}
+
+func TestMatchCacheErrorsAreNotCached(t *testing.T) {
+	cache := NewMatchCache(10)
+	instance, _ := NewTaggedUrnFromString("cap:op=generate")
+	media, _ := NewTaggedUrnFromString("media:pdf")
+
+	_, err := cache.Get(instance, media)
+	require.Error(t, err)
+	assert.Equal(t, ErrorPrefixMismatch, err.(*TaggedUrnError).Code)
+	assert.Equal(t, 0, cache.Len())
+
+	_, err = cache.Get(nil, media)
+	assert.Error(t, err)
+}
+
+func TestMatchCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewMatchCache(2)
+	pattern, _ := NewTaggedUrnFromString("cap:op=generate")
+	a, _ := NewTaggedUrnFromString("cap:op=generate;n=a")
+	b, _ := NewTaggedUrnFromString("cap:op=generate;n=b")
+	c, _ := NewTaggedUrnFromString("cap:op=generate;n=c")
+
+	_, _ = cache.Get(a, pattern)
+	_, _ = cache.Get(b, pattern)
+	_, _ = cache.Get(a, pattern) // a is now most recently used
+	_, _ = cache.Get(c, pattern) // evicts b
+	assert.Equal(t, 2, cache.Len())
+
+	cache.mu.Lock()
+	_, hasA := cache.entries[matchCacheKey{instance: a.ToString(), pattern: pattern.ToString()}]
+	_, hasB := cache.entries[matchCacheKey{instance: b.ToString(), pattern: pattern.ToString()}]
+	cache.mu.Unlock()
+	assert.True(t, hasA)
+	assert.False(t, hasB)
+
+	assert.Equal(t, 1, NewMatchCache(0).capacity)
+}
+
+func TestMatchCacheConcurrentUse(t *testing.T) {
+	cache := NewMatchCache(16)
+	pattern, _ := NewTaggedUrnFromString("cap:op=generate")
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				instance, _ := NewTaggedUrnFromString(fmt.Sprintf("cap:op=generate;n=v%d", (i+w)%32))
+				matches, err := cache.Get(instance, pattern)
+				assert.NoError(t, err)
+				assert.True(t, matches)
+			}
+		}(w)
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, cache.Len(), 16)
+}
+
+func TestMatchCacheDropsResultsOnRegisterEnum(t *testing.T) {
+	RegisterEnum("cache-formats", []string{"pdf"})
+	cache := NewMatchCache(10)
+	instance, _ := NewTaggedUrnFromString("cap:ext=docx")
+	pattern, _ := NewTaggedUrnFromString("cap:ext=@cache-formats")
+
+	matches, err := cache.Get(instance, pattern)
+	require.NoError(t, err)
+	assert.False(t, matches)
+	assert.Equal(t, 1, cache.Len())
+
+	RegisterEnum("cache-formats", []string{"pdf", "docx"})
+	assert.Equal(t, 1, cache.Len())
+	matches, err = cache.Get(instance, pattern)
+	require.NoError(t, err)
+	assert.True(t, matches)
+	assert.Equal(t, 1, cache.Len())
+}
@@ -0,0 +1,137 @@
+package taggedurn
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Vector is a single cross-language conformance case: whether Instance
+// conforms to Pattern. The same vector files are shared by the Rust, Go,
+// JavaScript and Objective-C implementations.
+type Vector struct {
+	Name          string `json:"name,omitempty"`
+	Instance      string `json:"instance"`
+	Pattern       string `json:"pattern"`
+	ExpectedMatch bool   `json:"expectedMatch"`
+}
+
+// vectorJSON mirrors Vector with pointer fields so missing keys can be detected
+type vectorJSON struct {
+	Name          string  `json:"name,omitempty"`
+	Instance      *string `json:"instance"`
+	Pattern       *string `json:"pattern"`
+	ExpectedMatch *bool   `json:"expectedMatch"`
+}
+
+// TestingT is the subset of *testing.T used by the conformance helpers.
+// It keeps the "testing" package out of non-test builds.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// LoadConformanceVectors reads a JSON array of conformance vectors
+// Each element must provide "instance", "pattern" and "expectedMatch";
+// "name" is optional. Unknown fields are rejected so that typos in the
+// shared vector file fail loudly instead of silently defaulting.
+func LoadConformanceVectors(r io.Reader) ([]Vector, error) {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	var raw []vectorJSON
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to decode conformance vectors: %w", err)
+	}
+
+	vectors := make([]Vector, 0, len(raw))
+	for i, v := range raw {
+		if v.Instance == nil || v.Pattern == nil || v.ExpectedMatch == nil {
+			return nil, fmt.Errorf("conformance vector %d (%q): instance, pattern and expectedMatch are required", i, v.Name)
+		}
+		vectors = append(vectors, Vector{
+			Name:          v.Name,
+			Instance:      *v.Instance,
+			Pattern:       *v.Pattern,
+			ExpectedMatch: *v.ExpectedMatch,
+		})
+	}
+	return vectors, nil
+}
+
+// RunConformance checks every vector against ConformsTo and reports each
+// mismatch (or parse failure) through t.Errorf
+func RunConformance(t TestingT, vectors []Vector) {
+	t.Helper()
+	for i, v := range vectors {
+		label := v.Name
+		if label == "" {
+			label = fmt.Sprintf("vector %d", i)
+		}
+
+		instance, err := NewTaggedUrnFromString(v.Instance)
+		if err != nil {
+			t.Errorf("%s: invalid instance '%s': %v", label, v.Instance, err)
+			continue
+		}
+		pattern, err := NewTaggedUrnFromString(v.Pattern)
+		if err != nil {
+			t.Errorf("%s: invalid pattern '%s': %v", label, v.Pattern, err)
+			continue
+		}
+
+		matches, err := instance.ConformsTo(pattern)
+		if err != nil {
+			t.Errorf("%s: instance=%s, pattern=%s: %v", label, v.Instance, v.Pattern, err)
+			continue
+		}
+		if matches != v.ExpectedMatch {
+			t.Errorf("%s: instance=%s, pattern=%s: expected match=%t, got %t", label, v.Instance, v.Pattern, v.ExpectedMatch, matches)
+		}
+	}
+}
+
+// canonicalIterations is how many re-parse rounds AssertCanonical performs
+const canonicalIterations = 100
+
+// AssertCanonical checks that s parses and that its canonical form is stable
+// It repeatedly re-parses the ToString output, and rebuilds the URN from a
+// fresh copy of its tag map (keeping which values are quoted literals),
+// asserting the same string, hash and Equals each time. Since tags live in a
+// map, this catches code that accidentally depends on Go's randomized map
+// iteration order. The first failure is reported through t.Errorf.
+func AssertCanonical(t TestingT, s string) {
+	t.Helper()
+	original, err := NewTaggedUrnFromString(s)
+	if err != nil {
+		t.Errorf("'%s' does not parse: %v", s, err)
+		return
+	}
+
+	canonical := original.ToString()
+	hash := original.Hash()
+	for i := 0; i < canonicalIterations; i++ {
+		reparsed, err := NewTaggedUrnFromString(canonical)
+		if err != nil {
+			t.Errorf("canonical form '%s' of '%s' does not parse: %v", canonical, s, err)
+			return
+		}
+		tags := reparsed.AllTags()
+		rebuilt := &TaggedUrn{prefix: reparsed.prefix, tags: tags, literals: reparsed.literalsFor(tags)}
+
+		for _, urn := range []*TaggedUrn{reparsed, rebuilt} {
+			if got := urn.ToString(); got != canonical {
+				t.Errorf("iteration %d: canonical form of '%s' changed from '%s' to '%s'", i, s, canonical, got)
+				return
+			}
+			if !urn.Equals(original) || !original.Equals(urn) {
+				t.Errorf("iteration %d: re-parsed '%s' is not equal to the original", i, canonical)
+				return
+			}
+			if urn.Hash() != hash {
+				t.Errorf("iteration %d: hash of '%s' changed", i, canonical)
+				return
+			}
+		}
+	}
+}
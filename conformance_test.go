@@ -0,0 +1,97 @@
+package taggedurn
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingT captures conformance failures instead of failing the real test
+type recordingT struct {
+	errors []string
+}
+
+func (r *recordingT) Helper() {}
+
+func (r *recordingT) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestSharedConformanceVectors(t *testing.T) {
+	f, err := os.Open("testdata/conformance_vectors.json")
+	require.NoError(t, err)
+	defer f.Close()
+
+	vectors, err := LoadConformanceVectors(f)
+	require.NoError(t, err)
+	require.NotEmpty(t, vectors)
+
+	RunConformance(t, vectors)
+}
+
+func TestLoadConformanceVectors(t *testing.T) {
+	input := `[
+		{"name": "exact", "instance": "cap:ext=pdf", "pattern": "cap:ext=pdf", "expectedMatch": true},
+		{"instance": "cap:", "pattern": "cap:ext", "expectedMatch": false}
+	]`
+	vectors, err := LoadConformanceVectors(strings.NewReader(input))
+	require.NoError(t, err)
+	require.Len(t, vectors, 2)
+
+	assert.Equal(t, Vector{Name: "exact", Instance: "cap:ext=pdf", Pattern: "cap:ext=pdf", ExpectedMatch: true}, vectors[0])
+	assert.Equal(t, "", vectors[1].Name)
+	assert.False(t, vectors[1].ExpectedMatch)
+}
+
+func TestLoadConformanceVectorsRejectsMissingFields(t *testing.T) {
+	_, err := LoadConformanceVectors(strings.NewReader(`[{"instance": "cap:", "pattern": "cap:"}]`))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "expectedMatch")
+
+	_, err = LoadConformanceVectors(strings.NewReader(`[{"instance": "cap:", "expectedMatch": true}]`))
+	assert.Error(t, err)
+}
+
+func TestLoadConformanceVectorsRejectsUnknownFields(t *testing.T) {
+	_, err := LoadConformanceVectors(strings.NewReader(`[{"instance": "cap:", "pattern": "cap:", "expected": true}]`))
+	assert.Error(t, err)
+}
+
+func TestRunConformanceReportsMismatches(t *testing.T) {
+	rec := &recordingT{}
+	RunConformance(rec, []Vector{
+		{Name: "ok", Instance: "cap:ext=pdf", Pattern: "cap:ext", ExpectedMatch: true},
+		{Name: "wrong", Instance: "cap:ext=pdf", Pattern: "cap:ext=doc", ExpectedMatch: true},
+		{Name: "invalid", Instance: "cap:ext=", Pattern: "cap:", ExpectedMatch: true},
+		{Name: "prefix", Instance: "cap:ext=pdf", Pattern: "media:", ExpectedMatch: true},
+	})
+
+	require.Len(t, rec.errors, 3)
+	assert.Contains(t, rec.errors[0], "wrong")
+	assert.Contains(t, rec.errors[1], "invalid")
+	assert.Contains(t, rec.errors[2], "prefix")
+}
+
+func TestAssertCanonical(t *testing.T) {
+	for _, s := range []string{
+		"cap:",
+		"cap:op=generate",
+		"cap:z=1;y=2;x=3;w=4;v=5;u=6;t=7;s=8;r=9;q=10;p=11;o=12",
+		`cap:Name="Hello World";ext;debug=!;draft=?;path=/a/b`,
+		`media:quoted="a;b=c";escaped="say \"hi\"";colon="x:y"`,
+		"paint:color=@rgb;finish=~matte",
+		`cap:x="@rgb"`,
+		`cap:x="~matte";y="/^a/"`,
+	} {
+		AssertCanonical(t, s)
+	}
+
+	rec := &recordingT{}
+	AssertCanonical(rec, "cap:ext=")
+	require.Len(t, rec.errors, 1)
+	assert.Contains(t, rec.errors[0], "does not parse")
+}
@@ -0,0 +1,58 @@
+package taggedurn
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// EnumRegistry maps enum names to their allowed values
+// A pattern value of the form "@name" matches any instance value registered
+// under name, so color=@rgb can stand in for a shared red/green/blue list.
+// Names are case-insensitive; values compare exactly, like any other tag
+// value. Safe for concurrent use.
+type EnumRegistry struct {
+	mu    sync.RWMutex
+	enums map[string][]string
+}
+
+// NewEnumRegistry creates an empty enum registry
+func NewEnumRegistry() *EnumRegistry {
+	return &EnumRegistry{enums: make(map[string][]string)}
+}
+
+// Register defines (or redefines) the enum name with the given values
+func (r *EnumRegistry) Register(name string, values []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enums[strings.ToLower(name)] = append([]string(nil), values...)
+}
+
+// Lookup returns the values registered under name
+func (r *EnumRegistry) Lookup(name string) ([]string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	values, exists := r.enums[strings.ToLower(name)]
+	return values, exists
+}
+
+// defaultEnums is consulted when MatchOptions.Enums is nil
+var defaultEnums = NewEnumRegistry()
+
+// enumGeneration counts changes to defaultEnums, letting caches of match
+// results (see MatchCache) notice that they may be stale
+var enumGeneration atomic.Uint64
+
+// RegisterEnum defines an enum in the package-wide registry used by
+// ConformsTo, Accepts and any MatchOptions without its own Enums
+// Since it can change the outcome of earlier matches, every MatchCache is
+// emptied on its next use.
+func RegisterEnum(name string, values []string) {
+	defaultEnums.Register(name, values)
+	enumGeneration.Add(1)
+}
+
+// isEnumReference checks if a tag value is an "@name" enum reference
+func isEnumReference(value string) bool {
+	return len(value) > 1 && value[0] == '@'
+}
@@ -0,0 +1,126 @@
+package taggedurn
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnumPatternMatching(t *testing.T) {
+	RegisterEnum("rgb", []string{"red", "green", "blue"})
+
+	pattern, err := NewTaggedUrnFromString("paint:color=@RGB;finish=matte")
+	require.NoError(t, err)
+	assert.Equal(t, "paint:color=@rgb;finish=matte", pattern.ToString())
+
+	for _, tc := range []struct {
+		instance string
+		expected bool
+	}{
+		{"paint:color=red;finish=matte", true},
+		{"paint:color=blue;finish=matte", true},
+		{"paint:color=purple;finish=matte", false},
+		{"paint:finish=matte", false},
+		{"paint:color;finish=matte", true},
+		{"paint:color=!;finish=matte", false},
+		{"paint:color=@rgb;finish=matte", true},
+	} {
+		instance, err := NewTaggedUrnFromString(tc.instance)
+		require.NoError(t, err)
+		matches, err := instance.ConformsTo(pattern)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, matches, tc.instance)
+	}
+}
+
+func TestEnumUnknownName(t *testing.T) {
+	pattern, err := NewTaggedUrnFromString("paint:color=@unregistered")
+	require.NoError(t, err)
+	instance, err := NewTaggedUrnFromString("paint:color=red")
+	require.NoError(t, err)
+
+	_, err = instance.ConformsTo(pattern)
+	require.Error(t, err)
+	assert.Equal(t, ErrorUnknownEnum, err.(*TaggedUrnError).Code)
+
+	_, err = pattern.Accepts(instance)
+	assert.Error(t, err)
+}
+
+func TestEnumMatcherRegistry(t *testing.T) {
+	registry := NewEnumRegistry()
+	registry.Register("size", []string{"S", "M", "L"})
+
+	pattern, err := NewTaggedUrnFromString("shirt:size=@size")
+	require.NoError(t, err)
+	instance, err := NewTaggedUrnFromString(`shirt:size="M"`)
+	require.NoError(t, err)
+	lower, err := NewTaggedUrnFromString("shirt:size=m")
+	require.NoError(t, err)
+
+	matches, err := instance.MatchesWithOptions(pattern, MatchOptions{Enums: registry})
+	require.NoError(t, err)
+	assert.True(t, matches)
+
+	matches, err = lower.MatchesWithOptions(pattern, MatchOptions{Enums: registry})
+	require.NoError(t, err)
+	assert.False(t, matches)
+
+	matches, err = lower.MatchesWithOptions(pattern, MatchOptions{Enums: registry, CaseInsensitiveValues: true})
+	require.NoError(t, err)
+	assert.True(t, matches)
+
+	// The per-matcher registry does not leak into the package-wide one
+	_, err = instance.ConformsTo(pattern)
+	assert.Error(t, err)
+}
+
+func TestEnumKindAndSerialization(t *testing.T) {
+	assert.Equal(t, KindEnum, KindOf("@rgb"))
+	assert.Equal(t, KindExact, KindOf("@"))
+	assert.Equal(t, "enum", KindEnum.String())
+
+	pattern, err := NewTaggedUrnFromString("paint:color=@rgb;finish=matte")
+	require.NoError(t, err)
+	assert.True(t, pattern.IsPattern())
+	assert.Equal(t, 5, pattern.Specificity())
+
+	// '@' is only valid unquoted at the start of a value
+	_, err = NewTaggedUrnFromString("paint:color=a@b")
+	assert.Error(t, err)
+	literal, err := NewTaggedUrnFromString(`paint:color="a@b"`)
+	require.NoError(t, err)
+	assert.Equal(t, `paint:color="a@b"`, literal.ToString())
+}
+
+func TestEnumQuotedValueStaysLiteral(t *testing.T) {
+	pattern, err := NewTaggedUrnFromString(`cap:x="@foo"`)
+	require.NoError(t, err)
+	assert.Equal(t, `cap:x="@foo"`, pattern.ToString())
+	assert.True(t, pattern.IsConcreteInstance())
+	assert.Equal(t, []KeyConstraint{{Key: "x", Kind: KindExact, Value: "@foo"}}, pattern.Constraints())
+
+	// An unregistered name is no error: the quoted value is not a reference
+	other, err := NewTaggedUrnFromString("cap:x=bar")
+	require.NoError(t, err)
+	matches, err := other.ConformsTo(pattern)
+	require.NoError(t, err)
+	assert.False(t, matches)
+	matches, err = pattern.ConformsTo(pattern)
+	require.NoError(t, err)
+	assert.True(t, matches)
+
+	// The literal survives reparsing and derivation, and differs from the reference
+	reparsed, err := NewTaggedUrnFromString(pattern.ToString())
+	require.NoError(t, err)
+	assert.True(t, pattern.Equals(reparsed))
+	assert.Equal(t, `cap:op=a;x="@foo"`, pattern.WithTag("op", "a").ToString())
+	reference, err := NewTaggedUrnFromString("cap:x=@foo")
+	require.NoError(t, err)
+	assert.False(t, pattern.Equals(reference))
+	assert.NotEqual(t, pattern.Hash(), reference.Hash())
+
+	// WithTag stores its value as-is, so the same text becomes a reference
+	assert.Equal(t, KindEnum, pattern.WithTag("x", "@foo").Constraints()[0].Kind)
+}
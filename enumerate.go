@@ -0,0 +1,151 @@
+package taggedurn
+
+import (
+	"fmt"
+)
+
+// MaxEnumeratedInstances caps how many instances Enumerate will produce
+const MaxEnumeratedInstances = 10000
+
+// Enumerate returns every concrete instance that conforms to this pattern
+// when each open tag is restricted to a bounded domain of values
+// Per tag:
+// - K=v keeps v
+// - K=* takes each value in domains[K] (which must be provided)
+// - K=? is omitted, or takes each value in domains[K] when provided
+// - K=! is omitted
+// - K=@name takes each value of the registered enum
+// - K=~v is omitted, or takes v
+// - K=/re/ takes each value in domains[K] that re matches
+// The result is the cartesian product in canonical key order, with domain
+// values in the order given. More than MaxEnumeratedInstances results is an
+// ErrorEnumerationLimit error. Domain keys are case-insensitive like tag keys;
+// if two differ only in case, the all-lowercase one wins.
+func (c *TaggedUrn) Enumerate(domains map[string][]string) ([]*TaggedUrn, error) {
+	domains = lowercaseKeys(domains)
+	keys := c.sortedKeys()
+	// choices[i] lists the options for keys[i]; "" means the tag is omitted
+	choices := make([][]string, len(keys))
+	total := 1
+	for i, key := range keys {
+		value := c.tags[key]
+		var options []string
+		switch c.kindOf(key) {
+		case KindExact:
+			options = []string{value}
+		case KindMustHaveAny:
+			domain := domains[key]
+			if len(domain) == 0 {
+				return nil, &TaggedUrnError{
+					Code:    ErrorMissingDomain,
+					Message: fmt.Sprintf("no domain provided for must-have-any tag '%s'", key),
+				}
+			}
+			options = domain
+		case KindUnspecified:
+			options = append([]string{""}, domains[key]...)
+		case KindMustNotHave:
+			options = []string{""}
+		case KindEnum:
+			values, exists := defaultEnums.Lookup(value[1:])
+			if !exists {
+				return nil, &TaggedUrnError{
+					Code:    ErrorUnknownEnum,
+					Message: fmt.Sprintf("unknown enum '%s'", value[1:]),
+				}
+			}
+			options = values
+		case KindConditional:
+			options = []string{"", value[1:]}
+		case KindRegex:
+			domain := domains[key]
+			if len(domain) == 0 {
+				return nil, &TaggedUrnError{
+					Code:    ErrorMissingDomain,
+					Message: fmt.Sprintf("no domain provided for regex tag '%s'", key),
+				}
+			}
+			for _, v := range domain {
+				matches, err := regexMatches(v, value)
+				if err != nil {
+					return nil, err
+				}
+				if matches {
+					options = append(options, v)
+				}
+			}
+		}
+
+		choices[i] = options
+		total *= len(options)
+		if total > MaxEnumeratedInstances {
+			return nil, &TaggedUrnError{
+				Code:    ErrorEnumerationLimit,
+				Message: fmt.Sprintf("pattern '%s' expands to more than %d instances", c.ToString(), MaxEnumeratedInstances),
+			}
+		}
+	}
+
+	instances := make([]*TaggedUrn, 0, total)
+	if total == 0 {
+		return instances, nil
+	}
+	picks := make([]int, len(keys))
+	for {
+		tags := make(map[string]string, len(keys))
+		for i, key := range keys {
+			if v := choices[i][picks[i]]; v != "" {
+				tags[key] = v
+			}
+		}
+		// Domain values are set in code, so a "/.../" one stays literal
+		literals := c.literalsFor(tags)
+		for key, value := range tags {
+			literals = setLiteral(literals, key, literals[key] || isRegexValue(value))
+		}
+		instances = append(instances, &TaggedUrn{prefix: c.prefix, tags: tags, literals: literals})
+
+		// Advance the odometer, last key fastest
+		i := len(keys) - 1
+		for ; i >= 0; i-- {
+			picks[i]++
+			if picks[i] < len(choices[i]) {
+				break
+			}
+			picks[i] = 0
+		}
+		if i < 0 {
+			return instances, nil
+		}
+	}
+}
+
+// ViolatesDomains returns, in sorted order, the keys whose concrete value is
+// outside the allowed domain, such as a typo like ext=xyz where ext must be
+// pdf or docx
+// Exact values and the value of K=~v are checked; *, !, ?, @enum and /re/
+// name no single value and are skipped, as are keys without a domain.
+// Values compare exactly, like matching; domain keys are case-insensitive, as
+// in Enumerate.
+func (c *TaggedUrn) ViolatesDomains(domains map[string][]string) []string {
+	domains = lowercaseKeys(domains)
+	var keys []string
+	for _, key := range c.sortedKeys() {
+		domain, exists := domains[key]
+		if !exists {
+			continue
+		}
+		value := c.tags[key]
+		switch c.kindOf(key) {
+		case KindConditional:
+			value = value[1:]
+		case KindExact:
+		default:
+			continue
+		}
+		if !containsString(domain, value) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
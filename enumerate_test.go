@@ -0,0 +1,110 @@
+package taggedurn
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func enumeratedStrings(urns []*TaggedUrn) []string {
+	out := make([]string, len(urns))
+	for i, urn := range urns {
+		out[i] = urn.ToString()
+	}
+	return out
+}
+
+func TestEnumerate(t *testing.T) {
+	pattern, err := NewTaggedUrnFromString("cap:op=generate;ext;debug=!;quality=?")
+	require.NoError(t, err)
+
+	instances, err := pattern.Enumerate(map[string][]string{
+		"ext":     {"pdf", "png"},
+		"quality": {"high"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"cap:ext=pdf;op=generate",
+		"cap:ext=pdf;op=generate;quality=high",
+		"cap:ext=png;op=generate",
+		"cap:ext=png;op=generate;quality=high",
+	}, enumeratedStrings(instances))
+
+	for _, instance := range instances {
+		assert.True(t, instance.IsConcreteInstance())
+		matches, err := instance.ConformsTo(pattern)
+		require.NoError(t, err)
+		assert.True(t, matches, instance.ToString())
+	}
+
+	// Domain keys match tag keys case-insensitively
+	mixed, err := pattern.Enumerate(map[string][]string{
+		"EXT":     {"pdf", "png"},
+		"Quality": {"high"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, enumeratedStrings(instances), enumeratedStrings(mixed))
+}
+
+func TestEnumerateExtendedKinds(t *testing.T) {
+	RegisterEnum("enumerate-sizes", []string{"s", "m"})
+	pattern, err := NewTaggedUrnFromString(`shirt:size=@enumerate-sizes;fit=~slim;sku=/^a\d$/`)
+	require.NoError(t, err)
+
+	instances, err := pattern.Enumerate(map[string][]string{"sku": {"a1", "b2", "a3"}})
+	require.NoError(t, err)
+	assert.Len(t, instances, 2*2*2)
+	for _, instance := range instances {
+		matches, err := instance.ConformsTo(pattern)
+		require.NoError(t, err)
+		assert.True(t, matches, instance.ToString())
+	}
+}
+
+func TestEnumerateErrors(t *testing.T) {
+	pattern, err := NewTaggedUrnFromString("cap:op=generate;ext")
+	require.NoError(t, err)
+	_, err = pattern.Enumerate(nil)
+	require.Error(t, err)
+	assert.Equal(t, ErrorMissingDomain, err.(*TaggedUrnError).Code)
+
+	wide, err := NewTaggedUrnFromString("cap:a;b;c")
+	require.NoError(t, err)
+	domain := make([]string, 30)
+	for i := range domain {
+		domain[i] = string(rune('a'+i%26)) + string(rune('a'+i/26))
+	}
+	_, err = wide.Enumerate(map[string][]string{"a": domain, "b": domain, "c": domain})
+	require.Error(t, err)
+	assert.Equal(t, ErrorEnumerationLimit, err.(*TaggedUrnError).Code)
+
+	// A concrete URN enumerates to itself; an empty one to the empty instance
+	concrete, err := NewTaggedUrnFromString("cap:op=generate")
+	require.NoError(t, err)
+	instances, err := concrete.Enumerate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cap:op=generate"}, enumeratedStrings(instances))
+	instances, err = Empty("cap").Enumerate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cap:"}, enumeratedStrings(instances))
+}
+
+func TestViolatesDomains(t *testing.T) {
+	domains := map[string][]string{
+		"ext":  {"pdf", "docx"},
+		"mode": {"fast", "slow"},
+		"out":  {"binary"},
+		"fmt":  {"pdf"},
+	}
+
+	pattern, err := NewTaggedUrnFromString("cap:op=generate;ext=xyz;mode=~turbo;out;fmt=@formats")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ext", "mode"}, pattern.ViolatesDomains(domains))
+
+	valid, err := NewTaggedUrnFromString("cap:op=generate;ext=pdf;mode=~fast;out=!")
+	require.NoError(t, err)
+	assert.Empty(t, valid.ViolatesDomains(domains))
+	assert.Empty(t, pattern.ViolatesDomains(nil))
+	assert.Equal(t, []string{"ext"}, pattern.ViolatesDomains(map[string][]string{"Ext": {"pdf"}}))
+}
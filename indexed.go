@@ -0,0 +1,102 @@
+package taggedurn
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// IndexedMatcher finds best matches against a fixed candidate set
+// The candidates are validated and ranked by Specificity once, when the
+// matcher is built, so each request scans them most specific first and stops
+// at the first conforming one instead of scoring the whole set. A match
+// equals UrnMatcher.FindBestMatch over the same candidates, including its
+// tie-break (the earliest candidate wins). Errors may differ: candidates
+// ranked below the match are never tried, so an error only one of them would
+// raise is not reported, and when several candidates fail the error comes
+// from the first in ranked rather than input order. Safe for concurrent use.
+type IndexedMatcher struct {
+	prefix     string
+	candidates []*TaggedUrn
+}
+
+// NewIndexedMatcher builds a matcher over candidates, which must share one
+// prefix (see SamePrefix)
+func NewIndexedMatcher(candidates []*TaggedUrn) (*IndexedMatcher, error) {
+	prefix, err := SamePrefix(candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	ranked := append([]*TaggedUrn(nil), candidates...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Specificity() > ranked[j].Specificity()
+	})
+	return &IndexedMatcher{prefix: prefix, candidates: ranked}, nil
+}
+
+// FindBestMatch returns the most specific candidate conforming to request,
+// or nil when none does
+func (m *IndexedMatcher) FindBestMatch(request *TaggedUrn) (*TaggedUrn, error) {
+	if request == nil {
+		return nil, &TaggedUrnError{
+			Code:    ErrorInvalidFormat,
+			Message: "cannot match against nil request",
+		}
+	}
+	if len(m.candidates) > 0 && request.prefix != m.prefix {
+		return nil, &TaggedUrnError{
+			Code:    ErrorPrefixMismatch,
+			Message: fmt.Sprintf("request has prefix '%s' but candidates have prefix '%s'", request.prefix, m.prefix),
+		}
+	}
+
+	for _, urn := range m.candidates {
+		ok, err := urn.ConformsTo(request)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return urn, nil
+		}
+	}
+	return nil, nil
+}
+
+// MatchResult is the outcome of matching one request from a stream
+// Best is nil when no candidate matches or Err is set.
+type MatchResult struct {
+	Request *TaggedUrn
+	Best    *TaggedUrn
+	Err     error
+}
+
+// MatchStream matches each request from requests against the candidates,
+// yielding one MatchResult per request in order
+// The returned channel is closed once requests is closed and drained, or as
+// soon as ctx is cancelled.
+func (m *IndexedMatcher) MatchStream(ctx context.Context, requests <-chan *TaggedUrn) <-chan MatchResult {
+	out := make(chan MatchResult)
+	go func() {
+		defer close(out)
+		for {
+			var request *TaggedUrn
+			var ok bool
+			select {
+			case <-ctx.Done():
+				return
+			case request, ok = <-requests:
+				if !ok {
+					return
+				}
+			}
+			best, err := m.FindBestMatch(request)
+			select {
+			case <-ctx.Done():
+				return
+			case out <- MatchResult{Request: request, Best: best, Err: err}:
+			}
+		}
+	}()
+	return out
+}
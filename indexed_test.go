@@ -0,0 +1,109 @@
+package taggedurn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func indexedCandidates(t *testing.T) []*TaggedUrn {
+	var urns []*TaggedUrn
+	for _, s := range []string{
+		"cap:op=generate",
+		"cap:op=generate;ext=pdf",
+		"cap:op=generate;ext=png",
+		"cap:op=extract;ext=pdf",
+	} {
+		urn, err := NewTaggedUrnFromString(s)
+		require.NoError(t, err)
+		urns = append(urns, urn)
+	}
+	return urns
+}
+
+func TestIndexedMatcherAgreesWithUrnMatcher(t *testing.T) {
+	candidates := indexedCandidates(t)
+	indexed, err := NewIndexedMatcher(candidates)
+	require.NoError(t, err)
+
+	matcher := &UrnMatcher{}
+	for _, s := range []string{"cap:op=generate", "cap:ext=pdf", "cap:op=extract", "cap:op=convert", "cap:"} {
+		request, err := NewTaggedUrnFromString(s)
+		require.NoError(t, err)
+		expected, err := matcher.FindBestMatch(candidates, request)
+		require.NoError(t, err)
+		best, err := indexed.FindBestMatch(request)
+		require.NoError(t, err)
+		assert.Same(t, expected, best, s)
+	}
+
+	// An invalid request fails the same way as with UrnMatcher
+	request, err := NewTaggedUrnFromString("cap:op=/[/")
+	require.NoError(t, err)
+	_, err = matcher.FindBestMatch(candidates, request)
+	require.Error(t, err)
+	_, err = indexed.FindBestMatch(request)
+	require.Error(t, err)
+	assert.Equal(t, ErrorInvalidRegex, err.(*TaggedUrnError).Code)
+
+	media, err := NewTaggedUrnFromString("media:op=generate")
+	require.NoError(t, err)
+	_, err = indexed.FindBestMatch(media)
+	require.Error(t, err)
+	assert.Equal(t, ErrorPrefixMismatch, err.(*TaggedUrnError).Code)
+
+	_, err = NewIndexedMatcher(append(candidates, media))
+	require.Error(t, err)
+	assert.Equal(t, ErrorPrefixMismatch, err.(*TaggedUrnError).Code)
+}
+
+func TestIndexedMatcherMatchStream(t *testing.T) {
+	indexed, err := NewIndexedMatcher(indexedCandidates(t))
+	require.NoError(t, err)
+
+	requests := make(chan *TaggedUrn, 3)
+	for _, s := range []string{"cap:ext=pdf", "cap:op=convert"} {
+		request, err := NewTaggedUrnFromString(s)
+		require.NoError(t, err)
+		requests <- request
+	}
+	requests <- nil
+	close(requests)
+
+	var results []MatchResult
+	for result := range indexed.MatchStream(context.Background(), requests) {
+		results = append(results, result)
+	}
+	require.Len(t, results, 3)
+	assert.Equal(t, "cap:ext=pdf;op=generate", results[0].Best.ToString())
+	assert.NoError(t, results[0].Err)
+	assert.Nil(t, results[1].Best)
+	assert.NoError(t, results[1].Err)
+	assert.Error(t, results[2].Err)
+}
+
+func TestIndexedMatcherMatchStreamCancel(t *testing.T) {
+	indexed, err := NewIndexedMatcher(indexedCandidates(t))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	requests := make(chan *TaggedUrn) // never closed
+	out := indexed.MatchStream(ctx, requests)
+
+	request, err := NewTaggedUrnFromString("cap:op=generate")
+	require.NoError(t, err)
+	requests <- request
+	result := <-out
+	assert.Equal(t, "cap:ext=pdf;op=generate", result.Best.ToString())
+
+	cancel()
+	select {
+	case _, ok := <-out:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("MatchStream did not stop after cancellation")
+	}
+}
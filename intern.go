@@ -0,0 +1,41 @@
+package taggedurn
+
+import "sync"
+
+// Interner deduplicates equal URNs so that repeated values share one instance
+// URNs are keyed by canonical string: the first URN interned for a key is
+// returned for every later Equals-equal URN. Since URNs are immutable the
+// shared instance is safe to hand out. The zero value is ready to use and
+// safe for concurrent use. Interned URNs are never released; use a fresh
+// Interner per load to bound its lifetime.
+type Interner struct {
+	mu   sync.Mutex
+	urns map[string]*TaggedUrn
+}
+
+// Intern returns the shared instance equal to urn, registering urn if it is
+// the first of its value. A nil urn returns nil.
+func (in *Interner) Intern(urn *TaggedUrn) *TaggedUrn {
+	if urn == nil {
+		return nil
+	}
+	key := urn.canonical()
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if shared, ok := in.urns[key]; ok {
+		return shared
+	}
+	if in.urns == nil {
+		in.urns = make(map[string]*TaggedUrn)
+	}
+	in.urns[key] = urn
+	return urn
+}
+
+// Len returns the number of distinct URNs interned
+func (in *Interner) Len() int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return len(in.urns)
+}
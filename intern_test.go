@@ -0,0 +1,50 @@
+package taggedurn
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterner(t *testing.T) {
+	var in Interner
+
+	a, err := NewTaggedUrnFromString("cap:op=generate;ext=pdf")
+	require.NoError(t, err)
+	b, err := NewTaggedUrnFromString("CAP:ext=pdf;op=generate")
+	require.NoError(t, err)
+	require.NotSame(t, a, b)
+
+	assert.Same(t, a, in.Intern(a))
+	assert.Same(t, a, in.Intern(b))
+	assert.Equal(t, 1, in.Len())
+
+	other, err := NewTaggedUrnFromString("media:op=generate;ext=pdf")
+	require.NoError(t, err)
+	assert.Same(t, other, in.Intern(other))
+	assert.Equal(t, 2, in.Len())
+
+	assert.Nil(t, in.Intern(nil))
+}
+
+func TestInternerConcurrent(t *testing.T) {
+	var in Interner
+	results := make([]*TaggedUrn, 16)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			urn, _ := NewTaggedUrnFromString("cap:op=generate")
+			results[i] = in.Intern(urn)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, urn := range results {
+		assert.Same(t, results[0], urn)
+	}
+	assert.Equal(t, 1, in.Len())
+}
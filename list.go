@@ -0,0 +1,202 @@
+package taggedurn
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ParseList parses several URNs joined by sep, e.g. "cap:op=a|cap:op=b|test:x=y"
+// with sep "|", returning them in order
+// sep must be non-empty and must not contain characters that are valid in
+// unquoted URN syntax (letters, digits, '_', '-', '/', ':', '.', '*', '?',
+// '!', '=', ';', '@', '~', '$') or the quote and escape characters, so it
+// can never be confused with tag content. Occurrences inside quoted values
+// and unquoted /regex/ values are not separators: a regex ends at the first
+// '/' followed by sep, and never runs past a sep followed by another prefix
+// and ':', so cap:name=/^(a|b)$/ stays one URN. A segment that fails to
+// parse is reported as "segment N: ..." wrapping the *TaggedUrnError.
+func ParseList(s string, sep string) ([]*TaggedUrn, error) {
+	if sep == "" {
+		return nil, &TaggedUrnError{
+			Code:    ErrorInvalidFormat,
+			Message: "list separator cannot be empty",
+		}
+	}
+	for _, c := range sep {
+		if isValidKeyChar(c) || isValidUnquotedValueChar(c) || strings.ContainsRune(`=;"\@~$`, c) {
+			return nil, &TaggedUrnError{
+				Code:    ErrorInvalidFormat,
+				Message: fmt.Sprintf("list separator %q collides with URN syntax", sep),
+			}
+		}
+	}
+
+	segments := splitUnquoted(s, sep)
+	urns := make([]*TaggedUrn, 0, len(segments))
+	for i, segment := range segments {
+		urn, err := NewTaggedUrnFromString(segment)
+		if err != nil {
+			return nil, fmt.Errorf("segment %d: %w", i, err)
+		}
+		urns = append(urns, urn)
+	}
+	return urns, nil
+}
+
+// splitUnquoted splits s on sep, ignoring occurrences inside quoted values
+// and unquoted regex values
+// As in joinLines, a quote only opens a value directly after '='.
+func splitUnquoted(s string, sep string) []string {
+	var segments []string
+	start := 0
+	inQuotes := false
+	escaped := false
+	atSep := func(rest string) bool { return strings.HasPrefix(rest, sep) }
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inQuotes && c == '\\':
+			escaped = true
+		case inQuotes && c == '"':
+			inQuotes = false
+		case !inQuotes && c == '"' && i > 0 && s[i-1] == '=':
+			inQuotes = true
+		case !inQuotes && c == '/' && i > 0 && s[i-1] == '=':
+			// A regex never runs past a separator that starts another URN
+			limit := len(s)
+			for j := i + 1; j < len(s); j++ {
+				if atSep(s[j:]) && startsUrn(s[j+len(sep):]) {
+					limit = j
+					break
+				}
+			}
+			if end := regexValueEnd(s[:limit], i, atSep); end > i {
+				i = end - 1
+			}
+		case !inQuotes && strings.HasPrefix(s[i:], sep):
+			segments = append(segments, s[start:i])
+			start = i + len(sep)
+			i += len(sep) - 1
+		}
+	}
+	return append(segments, s[start:])
+}
+
+// startsUrn checks if s begins with a prefix and its ':', i.e. a ':' comes
+// before any character that would place s inside a tag
+func startsUrn(s string) bool {
+	i := strings.IndexAny(s, `:;=/"`)
+	return i > 0 && s[i] == ':'
+}
+
+// MarshalSlice encodes urns as a JSON array of canonical strings
+// The output equals json.Marshal over the slice, except that a nil slice
+// gives "[]", but it is built in a single buffer with one scratch buffer
+// reused for every URN. A nil entry is reported by index.
+func MarshalSlice(urns []*TaggedUrn) ([]byte, error) {
+	size := 2
+	for i, urn := range urns {
+		if urn == nil {
+			return nil, fmt.Errorf("cannot encode nil URN at index %d", i)
+		}
+		size += urn.canonicalSizeHint() + 3
+	}
+
+	b := make([]byte, 0, size)
+	var scratch []byte
+	b = append(b, '[')
+	for i, urn := range urns {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		scratch = urn.AppendTo(scratch[:0])
+		b = appendJSONString(b, scratch)
+	}
+	return append(b, ']'), nil
+}
+
+// UnmarshalSlice decodes a JSON array of URN strings, validating each element
+// JSON null decodes to a nil slice. An element that fails to parse is
+// reported as "element N: ..." wrapping the *TaggedUrnError.
+func UnmarshalSlice(data []byte) ([]*TaggedUrn, error) {
+	var strs []string
+	if err := json.Unmarshal(data, &strs); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal URN slice: %w", err)
+	}
+	if strs == nil {
+		return nil, nil
+	}
+
+	urns := make([]*TaggedUrn, len(strs))
+	for i, s := range strs {
+		urn, err := NewTaggedUrnFromString(s)
+		if err != nil {
+			return nil, fmt.Errorf("element %d: %w", i, err)
+		}
+		urns[i] = urn
+	}
+	return urns, nil
+}
+
+// appendJSONString appends s as a JSON string literal, escaping exactly as
+// encoding/json does (including its HTML-safe escapes)
+func appendJSONString(b []byte, s []byte) []byte {
+	const hex = "0123456789abcdef"
+	b = append(b, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			switch {
+			case c == '"' || c == '\\':
+				b = append(b, '\\', c)
+			case c == '\n':
+				b = append(b, '\\', 'n')
+			case c == '\r':
+				b = append(b, '\\', 'r')
+			case c == '\t':
+				b = append(b, '\\', 't')
+			case c < 0x20 || c == '<' || c == '>' || c == '&':
+				b = append(b, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+			default:
+				b = append(b, c)
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			b = append(b, `\ufffd`...)
+		case r == '\u2028' || r == '\u2029':
+			b = append(b, '\\', 'u', '2', '0', '2', hex[r&0xf])
+		default:
+			b = append(b, s[i:i+size]...)
+		}
+		i += size
+	}
+	return append(b, '"')
+}
+
+// FindDuplicates groups the indices of inputs by the canonical form they
+// parse to, so inputs differing only in tag order, casing or quoting land in
+// the same group
+// Every input appears in exactly one group; groups with more than one index
+// are duplicate clusters. Indices within a group are ascending. A malformed
+// input fails the whole call, reported as "input N: ..." wrapping the
+// *TaggedUrnError.
+func FindDuplicates(inputs []string) (map[string][]int, error) {
+	groups := make(map[string][]int, len(inputs))
+	for i, input := range inputs {
+		urn, err := NewTaggedUrnFromString(input)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+		key := urn.canonical()
+		groups[key] = append(groups[key], i)
+	}
+	return groups, nil
+}
@@ -0,0 +1,142 @@
+package taggedurn
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseList(t *testing.T) {
+	urns, err := ParseList(`cap:op=a|cap:op=b;note="x|y"|test:x=y`, "|")
+	require.NoError(t, err)
+	require.Len(t, urns, 3)
+	assert.Equal(t, "cap:op=a", urns[0].ToString())
+	assert.Equal(t, "cap:note=x|y;op=b", urns[1].ToString())
+	assert.Equal(t, "test:x=y", urns[2].ToString())
+
+	urns, err = ParseList("cap:op=a || cap:op=b", " || ")
+	require.NoError(t, err)
+	assert.Len(t, urns, 2)
+
+	// A separator inside an unquoted regex does not split it
+	urns, err = ParseList("cap:name=/^(a|b)$/", "|")
+	require.NoError(t, err)
+	require.Len(t, urns, 1)
+	assert.Equal(t, KindRegex, urns[0].kindOf("name"))
+
+	urns, err = ParseList("cap:name=/^(a|b)$/|cap:op=x;path=/a/b|test:x=/y+/", "|")
+	require.NoError(t, err)
+	require.Len(t, urns, 3)
+	assert.Equal(t, "cap:name=/^(a|b)$/", urns[0].ToString())
+	assert.Equal(t, "cap:op=x;path=/a/b", urns[1].ToString())
+	assert.Equal(t, "test:x=/y+/", urns[2].ToString())
+}
+
+func TestParseListErrors(t *testing.T) {
+	_, err := ParseList("cap:op=a|cap:op=b c|test:x=y", "|")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "segment 1")
+	var urnErr *TaggedUrnError
+	require.True(t, errors.As(err, &urnErr))
+	assert.Equal(t, ErrorInvalidCharacter, urnErr.Code)
+
+	_, err = ParseList("cap:op=a||cap:op=b", "|")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "segment 1")
+
+	for _, sep := range []string{"", ";", ",x", ":", "="} {
+		_, err = ParseList("cap:op=a", sep)
+		require.Error(t, err, sep)
+		assert.Equal(t, ErrorInvalidFormat, err.(*TaggedUrnError).Code, sep)
+	}
+}
+
+func TestMarshalSlice(t *testing.T) {
+	urns := []*TaggedUrn{}
+	for _, s := range []string{
+		"cap:op=generate;ext=pdf",
+		`cap:title="Say \"hi\" <now> & \\ then"`,
+		"cap:note=\"tab\there\nnext line\u2028é\"",
+		"media:",
+	} {
+		urn, err := NewTaggedUrnFromString(s)
+		require.NoError(t, err)
+		urns = append(urns, urn)
+	}
+
+	data, err := MarshalSlice(urns)
+	require.NoError(t, err)
+	expected, err := json.Marshal(urns)
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), string(data))
+
+	decoded, err := UnmarshalSlice(data)
+	require.NoError(t, err)
+	require.Len(t, decoded, len(urns))
+	for i := range urns {
+		assert.True(t, urns[i].Equals(decoded[i]))
+	}
+
+	empty, err := MarshalSlice(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "[]", string(empty))
+
+	_, err = MarshalSlice([]*TaggedUrn{urns[0], nil})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "index 1")
+}
+
+func TestUnmarshalSliceErrors(t *testing.T) {
+	urns, err := UnmarshalSlice([]byte("null"))
+	require.NoError(t, err)
+	assert.Nil(t, urns)
+
+	_, err = UnmarshalSlice([]byte(`["cap:op=a", "cap:op=a b"]`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "element 1")
+	var urnErr *TaggedUrnError
+	require.True(t, errors.As(err, &urnErr))
+	assert.Equal(t, ErrorInvalidCharacter, urnErr.Code)
+
+	_, err = UnmarshalSlice([]byte(`{"a": 1}`))
+	require.Error(t, err)
+}
+
+func BenchmarkMarshalSlice(b *testing.B) {
+	urn, _ := NewTaggedUrnFromString("cap:op=generate;ext=pdf;quality=high;target=thumbnail")
+	urns := make([]*TaggedUrn, 100)
+	for i := range urns {
+		urns[i] = urn
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = MarshalSlice(urns)
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	groups, err := FindDuplicates([]string{
+		"cap:b=2;a=1",
+		"cap:op=generate",
+		"CAP:a=1;b=2",
+		`cap:a="1";b=2;`,
+		"cap:op=Generate",
+		"cap:op=extract",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]int{
+		"cap:a=1;b=2":     {0, 2, 3},
+		"cap:op=generate": {1, 4},
+		"cap:op=extract":  {5},
+	}, groups)
+
+	_, err = FindDuplicates([]string{"cap:op=a", "not a urn"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "input 1")
+	var urnErr *TaggedUrnError
+	assert.True(t, errors.As(err, &urnErr))
+}
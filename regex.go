@@ -0,0 +1,99 @@
+package taggedurn
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// maxCachedRegexes bounds regexCache
+// Regex values come from parsed input, so an unbounded cache would let
+// untrusted URNs keep any number of compiled expressions alive.
+const maxCachedRegexes = 256
+
+// regexCache holds compiled regex pattern values, keyed by the raw "/.../"
+// value; once full, an arbitrary entry is evicted for each new one
+var regexCache = struct {
+	sync.Mutex
+	entries map[string]*regexp.Regexp
+}{entries: make(map[string]*regexp.Regexp)}
+
+// isRegexValue checks if a tag value is a "/.../" regex constraint
+// To keep ordinary paths such as "/a/b/" literal, the body between the
+// slashes must contain at least one character that is not allowed in an
+// unquoted value (e.g. ^, $, \, +, [ or a space). A body made only of plain
+// value characters, like /abc/, stays a literal; anchor it (/^abc/) to make
+// it a regex. Only unquoted parsed values and values added with
+// TaggedUrn.WithRegexTag are regexes: a quoted value of this form, or one
+// set in code through WithTag or NewTaggedUrnFromTags, is recorded as a
+// literal.
+func isRegexValue(value string) bool {
+	if len(value) < 3 || value[0] != '/' || value[len(value)-1] != '/' {
+		return false
+	}
+	for _, c := range value[1 : len(value)-1] {
+		if !isValidUnquotedValueChar(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// regexValueEnd returns the end of the unquoted "/.../" regex value starting
+// at s[i], just after its '=', or i when no regex starts there
+// Like the parser, it never lets a regex span a ';'. The value ends at the
+// first '/' that completes a regex value and is followed by the end of s, a
+// ';' or a position where atBoundary reports true, so scanners that split
+// or cut s skip a separator inside the regex body.
+func regexValueEnd(s string, i int, atBoundary func(rest string) bool) int {
+	if i >= len(s) || s[i] != '/' {
+		return i
+	}
+	for j := i + 1; j < len(s) && s[j] != ';'; j++ {
+		if s[j] != '/' || !isRegexValue(s[i:j+1]) {
+			continue
+		}
+		if rest := s[j+1:]; rest == "" || rest[0] == ';' || atBoundary(rest) {
+			return j + 1
+		}
+	}
+	return i
+}
+
+// compileRegexValue compiles a "/.../" value, reusing earlier compilations
+// It runs when a regex is first matched (or validated), never during parsing.
+func compileRegexValue(value string) (*regexp.Regexp, error) {
+	regexCache.Lock()
+	re, ok := regexCache.entries[value]
+	regexCache.Unlock()
+	if ok {
+		return re, nil
+	}
+
+	re, err := regexp.Compile(value[1 : len(value)-1])
+	if err != nil {
+		return nil, &TaggedUrnError{
+			Code:    ErrorInvalidRegex,
+			Message: fmt.Sprintf("invalid regex value '%s': %v", value, err),
+		}
+	}
+	regexCache.Lock()
+	defer regexCache.Unlock()
+	if len(regexCache.entries) >= maxCachedRegexes {
+		for key := range regexCache.entries {
+			delete(regexCache.entries, key)
+			break
+		}
+	}
+	regexCache.entries[value] = re
+	return re, nil
+}
+
+// regexMatches checks a concrete instance value against a "/.../" pattern value
+func regexMatches(inst, pattern string) (bool, error) {
+	re, err := compileRegexValue(pattern)
+	if err != nil {
+		return false, err
+	}
+	return re.MatchString(inst), nil
+}
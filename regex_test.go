@@ -0,0 +1,186 @@
+package taggedurn
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegexPatternValue(t *testing.T) {
+	pattern, err := NewTaggedUrnFromString(`media:name=/^img_\d+$/;ext=png`)
+	require.NoError(t, err)
+	name, _ := pattern.GetTag("name")
+	assert.Equal(t, `/^img_\d+$/`, name)
+	assert.Equal(t, `media:ext=png;name=/^img_\d+$/`, pattern.ToString())
+	assert.Equal(t, KindRegex, KindOf(name))
+	assert.Equal(t, 6, pattern.Specificity())
+
+	for _, tc := range []struct {
+		instance string
+		expected bool
+	}{
+		{"media:name=img_42;ext=png", true},
+		{"media:name=img_;ext=png", false},
+		{"media:name=photo_1;ext=png", false},
+		{"media:ext=png", false},
+		{"media:name;ext=png", true},
+		{"media:name=!;ext=png", false},
+	} {
+		instance, err := NewTaggedUrnFromString(tc.instance)
+		require.NoError(t, err)
+		matches, err := instance.ConformsTo(pattern)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, matches, tc.instance)
+	}
+}
+
+func TestRegexValuePreservesCase(t *testing.T) {
+	pattern, err := NewTaggedUrnFromString(`media:name=/^[A-Z]+$/`)
+	require.NoError(t, err)
+
+	upper, _ := NewTaggedUrnFromString(`media:name="ABC"`)
+	lower, _ := NewTaggedUrnFromString("media:name=abc")
+
+	matches, err := upper.ConformsTo(pattern)
+	require.NoError(t, err)
+	assert.True(t, matches)
+	matches, err = lower.ConformsTo(pattern)
+	require.NoError(t, err)
+	assert.False(t, matches)
+}
+
+func TestRegexPathsStayLiteral(t *testing.T) {
+	for _, s := range []string{"cap:path=/", "cap:path=/a/b/", "cap:path=/abc/"} {
+		urn, err := NewTaggedUrnFromString(s)
+		require.NoError(t, err)
+		path, _ := urn.GetTag("path")
+		assert.Equal(t, KindExact, KindOf(path), s)
+	}
+}
+
+func TestRegexInstancesAndCompatibility(t *testing.T) {
+	a, _ := NewTaggedUrnFromString(`media:name=/^img_\d+$/`)
+	same, _ := NewTaggedUrnFromString(`media:name=/^img_\d+$/`)
+	b, _ := NewTaggedUrnFromString(`media:name=/^img_.*$/`)
+
+	comparable, err := a.IsComparable(same)
+	require.NoError(t, err)
+	assert.True(t, comparable)
+
+	comparable, err = a.IsComparable(b)
+	require.NoError(t, err)
+	assert.False(t, comparable)
+
+	_, err = a.MatchesStrict(b)
+	require.Error(t, err)
+	assert.Equal(t, ErrorPatternUsedAsInstance, err.(*TaggedUrnError).Code)
+}
+
+func TestRegexInvalid(t *testing.T) {
+	// Parsing compiles nothing; the error surfaces at match time
+	pattern, err := NewTaggedUrnFromString(`media:name=/^img_(\d+$/`)
+	require.NoError(t, err)
+	err = pattern.ValidatePattern()
+	require.Error(t, err)
+	assert.Equal(t, ErrorInvalidRegex, err.(*TaggedUrnError).Code)
+
+	instance, _ := NewTaggedUrnFromString("media:name=x")
+	_, err = instance.ConformsTo(pattern)
+	require.Error(t, err)
+	assert.Equal(t, ErrorInvalidRegex, err.(*TaggedUrnError).Code)
+
+	_, err = instance.WithRegexTag("name", "(")
+	require.Error(t, err)
+	assert.Equal(t, ErrorInvalidRegex, err.(*TaggedUrnError).Code)
+}
+
+func TestRegexQuotedValueStaysLiteral(t *testing.T) {
+	// A quoted value of regex form is an exact value, never compiled
+	pattern, err := NewTaggedUrnFromString(`cap:x="/a(b/"`)
+	require.NoError(t, err)
+	assert.NoError(t, pattern.ValidatePattern())
+	assert.True(t, pattern.IsConcreteInstance())
+	_, err = pattern.MatchesStrict(pattern)
+	assert.NoError(t, err)
+
+	instance, _ := NewTaggedUrnFromString("cap:x=ab")
+	matches, err := instance.ConformsTo(pattern)
+	require.NoError(t, err)
+	assert.False(t, matches)
+	matches, err = pattern.ConformsTo(pattern)
+	require.NoError(t, err)
+	assert.True(t, matches)
+
+	// and keeps its quotes, so the canonical form and hash are unchanged
+	spaced, err := NewTaggedUrnFromString(`cap:x="/my dir/"`)
+	require.NoError(t, err)
+	assert.Equal(t, `cap:x="/my dir/"`, spaced.ToString())
+	reparsed, err := NewTaggedUrnFromString(spaced.ToString())
+	require.NoError(t, err)
+	assert.Equal(t, spaced.Hash(), reparsed.Hash())
+
+	lowered, err := ParseWithOptions(`cap:x="/A^/"`, ParseOptions{ForceLowercaseValues: true})
+	require.NoError(t, err)
+	assert.Equal(t, `cap:x="/a^/"`, lowered.ToString())
+}
+
+func TestRegexCacheIsBounded(t *testing.T) {
+	for i := 0; i < 2*maxCachedRegexes; i++ {
+		_, err := compileRegexValue(fmt.Sprintf("/^bounded_%d$/", i))
+		require.NoError(t, err)
+	}
+	regexCache.Lock()
+	defer regexCache.Unlock()
+	assert.LessOrEqual(t, len(regexCache.entries), maxCachedRegexes)
+}
+
+func TestRegexWithSemicolonIsQuoted(t *testing.T) {
+	// A value set in code is literal, so it is quoted and reads back unchanged
+	pattern := NewTaggedUrnFromTags("media", map[string]string{"name": "/a;b$/"})
+	assert.Equal(t, `media:name="/a;b$/"`, pattern.ToString())
+	reparsed, err := NewTaggedUrnFromString(pattern.ToString())
+	require.NoError(t, err)
+	assert.True(t, pattern.Equals(reparsed))
+	assert.True(t, reparsed.IsConcreteInstance())
+
+	// An unquoted regex ends at ';', so WithRegexTag refuses one containing it
+	_, err = pattern.WithRegexTag("name", "a;b$")
+	require.Error(t, err)
+	assert.Equal(t, ErrorInvalidRegex, err.(*TaggedUrnError).Code)
+}
+
+func TestRegexValueSetInCodeStaysLiteral(t *testing.T) {
+	base, err := NewTaggedUrnFromString("cap:op=open")
+	require.NoError(t, err)
+	instance, err := NewTaggedUrnFromString(`cap:op=open;path="xx My Docs yy"`)
+	require.NoError(t, err)
+
+	for _, pattern := range []*TaggedUrn{
+		base.WithTag("path", "/My Docs/"),
+		NewTaggedUrnFromTags("cap", map[string]string{"op": "open", "path": "/My Docs/"}),
+	} {
+		assert.Equal(t, `cap:op=open;path="/My Docs/"`, pattern.ToString())
+		assert.True(t, pattern.IsConcreteInstance())
+		matches, err := instance.ConformsTo(pattern)
+		require.NoError(t, err)
+		assert.False(t, matches)
+	}
+
+	// WithRegexTag is the explicit way to add a regex in code
+	pattern, err := base.WithRegexTag("Path", "My Docs")
+	require.NoError(t, err)
+	assert.Equal(t, "cap:op=open;path=/My Docs/", pattern.ToString())
+	matches, err := instance.ConformsTo(pattern)
+	require.NoError(t, err)
+	assert.True(t, matches)
+	reparsed, err := NewTaggedUrnFromString(pattern.ToString())
+	require.NoError(t, err)
+	assert.True(t, pattern.Equals(reparsed))
+
+	// A body of plain value characters would read back as a literal path
+	_, err = base.WithRegexTag("path", "abc")
+	require.Error(t, err)
+	assert.Equal(t, ErrorInvalidRegex, err.(*TaggedUrnError).Code)
+}
@@ -0,0 +1,71 @@
+package taggedurn
+
+import (
+	"context"
+	"fmt"
+)
+
+// Tagged pairs a URN with a payload, such as a handler or a description
+// The payload plays no part in matching or equality.
+type Tagged[V any] struct {
+	Urn   *TaggedUrn
+	Value V
+}
+
+// Registry routes requests to the payload of the best matching URN
+// It replaces keeping a slice of URNs and a parallel slice of payloads.
+type Registry[V any] struct {
+	prefix  string
+	entries []Tagged[V]
+}
+
+// NewRegistry creates a registry over entries, whose URNs must be non-nil
+// and share one prefix (see SamePrefix)
+func NewRegistry[V any](entries []Tagged[V]) (*Registry[V], error) {
+	urns := make([]*TaggedUrn, len(entries))
+	for i, entry := range entries {
+		urns[i] = entry.Urn
+	}
+	prefix, err := SamePrefix(urns)
+	if err != nil {
+		return nil, err
+	}
+	return &Registry[V]{prefix: prefix, entries: append([]Tagged[V](nil), entries...)}, nil
+}
+
+// Entries returns the registered entries in registration order
+func (r *Registry[V]) Entries() []Tagged[V] {
+	return append([]Tagged[V](nil), r.entries...)
+}
+
+// FindBestMatch returns the entry whose URN is the most specific to conform
+// to request, with the same tie-break as UrnMatcher.FindBestMatch (the
+// earliest entry wins)
+// The bool is false when no entry matches.
+func (r *Registry[V]) FindBestMatch(request *TaggedUrn) (Tagged[V], bool, error) {
+	if request == nil {
+		return Tagged[V]{}, false, &TaggedUrnError{
+			Code:    ErrorInvalidFormat,
+			Message: "cannot match against nil request",
+		}
+	}
+	if len(r.entries) > 0 && request.prefix != r.prefix {
+		return Tagged[V]{}, false, &TaggedUrnError{
+			Code:    ErrorPrefixMismatch,
+			Message: fmt.Sprintf("request has prefix '%s' but candidates have prefix '%s'", request.prefix, r.prefix),
+		}
+	}
+
+	best, err := bestMatchIndex(context.Background(), len(r.entries), func(i int) *TaggedUrn { return r.entries[i].Urn }, request)
+	if err != nil || best < 0 {
+		return Tagged[V]{}, false, err
+	}
+	return r.entries[best], true, nil
+}
+
+// FindBestMatchValue returns the payload of the best matching entry (see
+// FindBestMatch), or the zero value and false when no entry matches
+func (r *Registry[V]) FindBestMatchValue(request *TaggedUrn) (V, bool, error) {
+	entry, ok, err := r.FindBestMatch(request)
+	return entry.Value, ok, err
+}
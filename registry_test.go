@@ -0,0 +1,63 @@
+package taggedurn
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustTagged[V any](t *testing.T, s string, value V) Tagged[V] {
+	urn, err := NewTaggedUrnFromString(s)
+	require.NoError(t, err)
+	return Tagged[V]{Urn: urn, Value: value}
+}
+
+func TestRegistryFindBestMatchValue(t *testing.T) {
+	registry, err := NewRegistry([]Tagged[func() string]{
+		mustTagged(t, "cap:op=generate", func() string { return "generic" }),
+		mustTagged(t, "cap:op=generate;ext=pdf", func() string { return "pdf" }),
+		mustTagged(t, "cap:op=extract", func() string { return "extract" }),
+	})
+	require.NoError(t, err)
+
+	request, err := NewTaggedUrnFromString("cap:op=generate")
+	require.NoError(t, err)
+	handler, ok, err := registry.FindBestMatchValue(request)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "pdf", handler())
+
+	none, err := NewTaggedUrnFromString("cap:op=convert")
+	require.NoError(t, err)
+	handler, ok, err = registry.FindBestMatchValue(none)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Nil(t, handler)
+
+	media, err := NewTaggedUrnFromString("media:op=generate")
+	require.NoError(t, err)
+	_, _, err = registry.FindBestMatchValue(media)
+	require.Error(t, err)
+	assert.Equal(t, ErrorPrefixMismatch, err.(*TaggedUrnError).Code)
+}
+
+func TestRegistryPayloadDoesNotAffectMatching(t *testing.T) {
+	// Equal URNs with different payloads: the earliest wins, as in FindBestMatch
+	registry, err := NewRegistry([]Tagged[string]{
+		mustTagged(t, "cap:op=generate;ext=pdf", "first"),
+		mustTagged(t, "cap:ext=pdf;op=generate", "second"),
+	})
+	require.NoError(t, err)
+
+	request, err := NewTaggedUrnFromString("cap:ext=pdf")
+	require.NoError(t, err)
+	entry, ok, err := registry.FindBestMatch(request)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "first", entry.Value)
+	assert.True(t, entry.Urn.Equals(registry.Entries()[1].Urn))
+
+	_, err = NewRegistry([]Tagged[string]{{Urn: nil, Value: "x"}})
+	require.Error(t, err)
+}
@@ -0,0 +1,17 @@
+package taggedurn
+
+// StringFormatPattern is a regular expression approximating tagged URN
+// syntax, for JSON Schema and OpenAPI "pattern" fields
+// It uses only syntax shared by Go, ECMAScript and PCRE. It accepts every
+// string NewTaggedUrnFromString accepts, but also some it rejects (such as
+// purely numeric keys or characters outside the unquoted value set), so use
+// ValidateStringFormat for an exact check.
+const StringFormatPattern = `^[^\s:][^:]*:;*(?:[^\s;="\\]+(?:=(?:"(?:[^"\\]|\\["\\])*"|/[^;]*/|[^\s;="\\]+))?(?:;+[^\s;="\\]+(?:=(?:"(?:[^"\\]|\\["\\])*"|/[^;]*/|[^\s;="\\]+))?)*;*)?$`
+
+// ValidateStringFormat checks that s is a valid tagged URN string
+// It returns the parse error, or nil, making it suitable as a JSON Schema
+// "format" validator or OpenAPI string format callback.
+func ValidateStringFormat(s string) error {
+	_, err := NewTaggedUrnFromString(s)
+	return err
+}
@@ -0,0 +1,66 @@
+package taggedurn
+
+import (
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateStringFormat(t *testing.T) {
+	assert.NoError(t, ValidateStringFormat("cap:op=generate;ext=pdf"))
+	assert.NoError(t, ValidateStringFormat("cap:"))
+
+	err := ValidateStringFormat("cap:ext=")
+	require.Error(t, err)
+	assert.Equal(t, ErrorEmptyTag, err.(*TaggedUrnError).Code)
+	assert.Error(t, ValidateStringFormat("no-prefix"))
+}
+
+func TestStringFormatPattern(t *testing.T) {
+	pattern := regexp.MustCompile(StringFormatPattern)
+
+	valid := []string{
+		"cap:",
+		"cap:;",
+		"cap:op=generate",
+		"cap:op=generate;ext=pdf;",
+		"cap:ext;draft=!;quality=?",
+		`cap:name="Hello; World";x=1`,
+		`cap:quote="say \"hi\""`,
+		"cap:path=/a/b/c;ns:key=v.1",
+		"cap:color=@rgb;fit=~slim",
+		`media:name=/^img_\d+$/`,
+		"café:clé=valeur",
+	}
+	for _, s := range valid {
+		require.NoError(t, ValidateStringFormat(s), s)
+		assert.True(t, pattern.MatchString(s), s)
+	}
+
+	f, err := os.Open("testdata/conformance_vectors.json")
+	require.NoError(t, err)
+	defer f.Close()
+	vectors, err := LoadConformanceVectors(f)
+	require.NoError(t, err)
+	for _, v := range vectors {
+		assert.True(t, pattern.MatchString(v.Instance), v.Instance)
+		assert.True(t, pattern.MatchString(v.Pattern), v.Pattern)
+	}
+
+	for _, s := range []string{
+		"",
+		"no-prefix",
+		":op=generate",
+		" cap:op=generate",
+		"cap:op=generate ",
+		"cap:ext=",
+		`cap:name="unterminated`,
+		"cap:a=b=c",
+	} {
+		assert.False(t, pattern.MatchString(s), s)
+		assert.Error(t, ValidateStringFormat(s), s)
+	}
+}
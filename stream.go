@@ -0,0 +1,190 @@
+package taggedurn
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ParseResult is the outcome of parsing one input string
+// Index is the zero-based position of Input in the batch or stream it came
+// from, so results delivered out of order can be related back to their source.
+type ParseResult struct {
+	Urn   *TaggedUrn
+	Err   error
+	Input string
+	Index int
+}
+
+// parseJob is one numbered input handed to a DecodeMany worker
+type parseJob struct {
+	input string
+	index int
+}
+
+// DecodeMany parses strings from in using a pool of worker goroutines
+// Each input yields one ParseResult on the returned channel; results are not
+// guaranteed to arrive in input order, but Index records the order in which
+// inputs were received. The returned channel is closed once in
+// is closed and drained, or as soon as ctx is cancelled. A workers value below
+// 1 is treated as 1.
+func DecodeMany(ctx context.Context, in <-chan string, workers int) <-chan ParseResult {
+	if workers < 1 {
+		workers = 1
+	}
+
+	// A single feeder numbers the inputs so Index follows arrival order
+	jobs := make(chan parseJob)
+	go func() {
+		defer close(jobs)
+		for index := 0; ; index++ {
+			var input string
+			var ok bool
+			select {
+			case <-ctx.Done():
+				return
+			case input, ok = <-in:
+				if !ok {
+					return
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case jobs <- parseJob{input: input, index: index}:
+			}
+		}
+	}()
+
+	out := make(chan ParseResult)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				urn, err := NewTaggedUrnFromString(job.input)
+				select {
+				case <-ctx.Done():
+					return
+				case out <- ParseResult{Urn: urn, Err: err, Input: job.input, Index: job.index}:
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// EncodeNDJSON writes urns as newline-delimited JSON, one quoted canonical
+// string per line (the same encoding as MarshalJSON)
+// Encoding stops at the first write error from w.
+func EncodeNDJSON(w io.Writer, urns []*TaggedUrn) error {
+	bw := bufio.NewWriter(w)
+	for i, urn := range urns {
+		if urn == nil {
+			return fmt.Errorf("cannot encode nil URN at index %d", i)
+		}
+		data, err := json.Marshal(urn)
+		if err != nil {
+			return fmt.Errorf("failed to encode URN at index %d: %w", i, err)
+		}
+		if _, err := bw.Write(data); err != nil {
+			return fmt.Errorf("failed to write URN at index %d: %w", i, err)
+		}
+		if err := bw.WriteByte('\n'); err != nil {
+			return fmt.Errorf("failed to write URN at index %d: %w", i, err)
+		}
+	}
+	return bw.Flush()
+}
+
+// DecodeNDJSON reads newline-delimited JSON URN strings as written by
+// EncodeNDJSON
+// Input is read incrementally, one line at a time. Blank lines are skipped;
+// any other invalid line stops decoding with an error naming its 1-based line
+// number.
+func DecodeNDJSON(r io.Reader) ([]*TaggedUrn, error) {
+	br := bufio.NewReader(r)
+	var urns []*TaggedUrn
+	for lineNumber := 1; ; lineNumber++ {
+		line, readErr := br.ReadBytes('\n')
+		if readErr != nil && !errors.Is(readErr, io.EOF) {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, readErr)
+		}
+
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			var urn TaggedUrn
+			if err := json.Unmarshal(trimmed, &urn); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+			urns = append(urns, &urn)
+		}
+
+		if readErr != nil {
+			return urns, nil
+		}
+	}
+}
+
+// Decoder incrementally parses newline-delimited URN strings from chunks of
+// bytes, such as reads from a framed network transport
+// Write buffers data; Next returns one URN per complete line, keeping any
+// partial trailing line for later writes. Lines holding only whitespace are
+// skipped and a trailing "\r" is removed. Framing is purely by '\n', so a
+// URN whose quoted value contains a newline is split across lines and cannot
+// be carried this way; use EncodeNDJSON and DecodeNDJSON for such values.
+// Not safe for concurrent use.
+type Decoder struct {
+	buf []byte
+}
+
+// NewDecoder creates an empty Decoder
+func NewDecoder() *Decoder {
+	return &Decoder{}
+}
+
+// Write appends p to the buffer; it implements io.Writer and never fails
+func (d *Decoder) Write(p []byte) (int, error) {
+	d.buf = append(d.buf, p...)
+	return len(p), nil
+}
+
+// Next parses the next complete line
+// It returns io.EOF when no complete line is buffered; write more data and
+// call Next again. A line that fails to parse is consumed and its error
+// returned, so decoding can continue with the following line.
+func (d *Decoder) Next() (*TaggedUrn, error) {
+	for {
+		i := bytes.IndexByte(d.buf, '\n')
+		if i < 0 {
+			return nil, io.EOF
+		}
+		line := bytes.TrimSuffix(d.buf[:i], []byte{'\r'})
+		d.buf = d.buf[i+1:]
+		if len(bytes.TrimSpace(line)) > 0 {
+			return NewTaggedUrnFromString(string(line))
+		}
+	}
+}
+
+// Flush parses any buffered data left after the last newline as a final URN
+// It returns io.EOF when nothing but whitespace remains. The buffer is empty
+// afterwards. Call it once the stream has ended, after Next returns io.EOF.
+func (d *Decoder) Flush() (*TaggedUrn, error) {
+	line := bytes.TrimSuffix(d.buf, []byte{'\r'})
+	d.buf = nil
+	if len(bytes.TrimSpace(line)) == 0 {
+		return nil, io.EOF
+	}
+	return NewTaggedUrnFromString(string(line))
+}
@@ -0,0 +1,193 @@
+package taggedurn
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeMany(t *testing.T) {
+	inputs := make([]string, 0, 200)
+	for i := 0; i < 200; i++ {
+		inputs = append(inputs, fmt.Sprintf("cap:op=generate;n=v%d", i))
+	}
+	inputs = append(inputs, "cap:ext=", "not-a-urn")
+
+	in := make(chan string)
+	go func() {
+		defer close(in)
+		for _, s := range inputs {
+			in <- s
+		}
+	}()
+
+	seen := make(map[string]ParseResult)
+	for result := range DecodeMany(context.Background(), in, 8) {
+		seen[result.Input] = result
+	}
+
+	require.Len(t, seen, len(inputs))
+	for i := 0; i < 200; i++ {
+		result := seen[fmt.Sprintf("cap:op=generate;n=v%d", i)]
+		require.NoError(t, result.Err)
+		assert.True(t, result.Urn.HasTag("n", fmt.Sprintf("v%d", i)))
+		assert.Equal(t, i, result.Index)
+	}
+	assert.Equal(t, 200, seen["cap:ext="].Index)
+	assert.Equal(t, 201, seen["not-a-urn"].Index)
+	assert.Error(t, seen["cap:ext="].Err)
+	assert.Nil(t, seen["cap:ext="].Urn)
+	assert.Error(t, seen["not-a-urn"].Err)
+}
+
+func TestDecodeManyZeroWorkers(t *testing.T) {
+	in := make(chan string, 1)
+	in <- "cap:op=generate"
+	close(in)
+
+	var results []ParseResult
+	for result := range DecodeMany(context.Background(), in, 0) {
+		results = append(results, result)
+	}
+	require.Len(t, results, 1)
+	assert.NoError(t, results[0].Err)
+}
+
+func TestDecodeManyCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan string) // never closed: only cancellation can stop the workers
+
+	out := DecodeMany(ctx, in, 4)
+	in <- "cap:op=generate"
+	first := <-out
+	require.NoError(t, first.Err)
+
+	cancel()
+	select {
+	case _, ok := <-out:
+		// A result may still be in flight; the channel must close afterwards
+		if ok {
+			_, ok = <-out
+		}
+		assert.False(t, ok)
+	case <-time.After(2 * time.Second):
+		t.Fatal("DecodeMany did not stop after cancellation")
+	}
+}
+
+func TestNDJSONRoundTrip(t *testing.T) {
+	a, _ := NewTaggedUrnFromString("cap:op=generate;ext=pdf")
+	b, _ := NewTaggedUrnFromString(`cap:name="Hello World";draft`)
+
+	var buf bytes.Buffer
+	require.NoError(t, EncodeNDJSON(&buf, []*TaggedUrn{a, b}))
+	assert.Equal(t, "\"cap:ext=pdf;op=generate\"\n\"cap:draft;name=\\\"Hello World\\\"\"\n", buf.String())
+
+	decoded, err := DecodeNDJSON(&buf)
+	require.NoError(t, err)
+	require.Len(t, decoded, 2)
+	assert.True(t, a.Equals(decoded[0]))
+	assert.True(t, b.Equals(decoded[1]))
+}
+
+func TestEncodeNDJSONWriteError(t *testing.T) {
+	urn, _ := NewTaggedUrnFromString("cap:op=generate;ext=pdf")
+
+	// Small output only reaches w on the final flush
+	err := EncodeNDJSON(failingWriter{}, []*TaggedUrn{urn})
+	assert.EqualError(t, err, "write failed")
+
+	// Output larger than the buffer fails while writing, before the flush
+	urns := make([]*TaggedUrn, 1000)
+	for i := range urns {
+		urns[i] = urn
+	}
+	err = EncodeNDJSON(failingWriter{}, urns)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to write URN at index")
+	assert.Contains(t, err.Error(), "write failed")
+}
+
+func TestDecodeNDJSON(t *testing.T) {
+	// Blank lines are skipped and the last line needs no newline
+	decoded, err := DecodeNDJSON(strings.NewReader("\"cap:op=a\"\n\n  \n\"cap:op=b\""))
+	require.NoError(t, err)
+	require.Len(t, decoded, 2)
+	assert.Equal(t, "cap:op=b", decoded[1].ToString())
+
+	decoded, err = DecodeNDJSON(strings.NewReader(""))
+	require.NoError(t, err)
+	assert.Empty(t, decoded)
+
+	_, err = DecodeNDJSON(strings.NewReader("\"cap:op=a\"\n\"cap:ext=\"\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "line 2")
+
+	_, err = DecodeNDJSON(strings.NewReader("\"cap:op=a\"\nnot json\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "line 2")
+
+	assert.Error(t, EncodeNDJSON(&bytes.Buffer{}, []*TaggedUrn{nil}))
+}
+
+func TestDecoderAcrossChunks(t *testing.T) {
+	d := NewDecoder()
+
+	_, err := d.Write([]byte("cap:op=gen"))
+	require.NoError(t, err)
+	_, err = d.Next()
+	assert.Equal(t, io.EOF, err)
+
+	_, _ = d.Write([]byte("erate;ext=pdf\n\r\n \t\nmedia:p"))
+	urn, err := d.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "cap:ext=pdf;op=generate", urn.ToString())
+
+	// Blank and whitespace-only lines are skipped and the partial line is retained
+	_, err = d.Next()
+	assert.Equal(t, io.EOF, err)
+
+	_, _ = d.Write([]byte("df\r\ncap:ext=\ncap:op=next"))
+	urn, err = d.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "media:pdf", urn.ToString())
+
+	// A bad line reports its error without blocking later lines
+	_, err = d.Next()
+	require.Error(t, err)
+	assert.NotEqual(t, io.EOF, err)
+	_, err = d.Next()
+	assert.Equal(t, io.EOF, err)
+
+	urn, err = d.Flush()
+	require.NoError(t, err)
+	assert.Equal(t, "cap:op=next", urn.ToString())
+	_, err = d.Flush()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestDecoderAsWriter(t *testing.T) {
+	d := NewDecoder()
+	_, err := io.Copy(d, strings.NewReader("cap:op=a\ncap:op=b\n"))
+	require.NoError(t, err)
+
+	var got []string
+	for {
+		urn, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		got = append(got, urn.ToString())
+	}
+	assert.Equal(t, []string{"cap:op=a", "cap:op=b"}, got)
+	_, err = d.Flush()
+	assert.Equal(t, io.EOF, err)
+}
@@ -0,0 +1,246 @@
+package taggedurn
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// structField describes one struct field mapped by a `urn:"key,options"` tag
+type structField struct {
+	name      string
+	key       string
+	index     int
+	omitEmpty bool
+	required  bool
+	rest      bool
+}
+
+// fieldValueError reports a tag value ToStruct cannot convert to the type of field
+func fieldValueError(field structField, value string, t reflect.Type, cause error) error {
+	message := fmt.Sprintf("field %s: cannot convert tag '%s' value '%s' to %s", field.name, field.key, value, t)
+	if cause != nil {
+		message += ": " + cause.Error()
+	}
+	return &TaggedUrnError{Code: ErrorInvalidValue, Message: message}
+}
+
+// structFields collects the URN-mapped fields of a struct type
+// Unexported fields and fields tagged `urn:"-"` are skipped.
+// Without an explicit key the lowercased field name is used.
+func structFields(t reflect.Type) []structField {
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		tag := f.Tag.Get("urn")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		field := structField{name: f.Name, key: strings.ToLower(name), index: i}
+		if field.key == "" {
+			field.key = strings.ToLower(f.Name)
+		}
+		for _, opt := range strings.Split(opts, ",") {
+			switch opt {
+			case "omitempty":
+				field.omitEmpty = true
+			case "required":
+				field.required = true
+			case "rest":
+				field.rest = true
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// structValue dereferences v and checks that it is a struct
+func structValue(v interface{}) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return reflect.Value{}, fmt.Errorf("cannot map nil %s to a tagged URN", rv.Type())
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("expected a struct, got %s", rv.Kind())
+	}
+	return rv, nil
+}
+
+// FromStruct creates a tagged URN from the fields of a struct (or pointer to struct)
+// Fields are mapped by their `urn:"key"` struct tag, falling back to the lowercased
+// field name, following encoding/json conventions:
+// - `urn:"-"` skips the field
+// - `urn:"key,omitempty"` skips the field when it holds its zero value
+// - `urn:",rest"` on a map[string]string field adds each entry as a tag
+// Supported field types are string, signed/unsigned integers and bool.
+// Empty strings are always skipped since a tag cannot have an empty value.
+func FromStruct(prefix string, v interface{}) (*TaggedUrn, error) {
+	if prefix == "" {
+		return nil, &TaggedUrnError{
+			Code:    ErrorEmptyPrefix,
+			Message: "tagged URN prefix cannot be empty",
+		}
+	}
+	if err := checkReservedPrefix(NormalizePrefix(prefix)); err != nil {
+		return nil, err
+	}
+
+	rv, err := structValue(v)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]string)
+	var rest map[string]string
+	for _, field := range structFields(rv.Type()) {
+		fv := rv.Field(field.index)
+		if field.rest {
+			m, ok := fv.Interface().(map[string]string)
+			if !ok {
+				return nil, fmt.Errorf("field %s: rest field must be map[string]string, got %s", field.name, fv.Type())
+			}
+			rest = m
+			continue
+		}
+
+		if err := validateKey(field.key); err != nil {
+			return nil, err
+		}
+		if field.omitEmpty && fv.IsZero() {
+			continue
+		}
+
+		var value string
+		switch fv.Kind() {
+		case reflect.String:
+			value = fv.String()
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			value = strconv.FormatInt(fv.Int(), 10)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			value = strconv.FormatUint(fv.Uint(), 10)
+		case reflect.Bool:
+			value = strconv.FormatBool(fv.Bool())
+		default:
+			return nil, fmt.Errorf("field %s: unsupported type %s for tag '%s'", field.name, fv.Type(), field.key)
+		}
+		if value == "" {
+			continue
+		}
+
+		if _, exists := tags[field.key]; exists {
+			return nil, &TaggedUrnError{
+				Code:    ErrorDuplicateKey,
+				Message: fmt.Sprintf("duplicate tag key: %s", field.key),
+			}
+		}
+		tags[field.key] = value
+	}
+
+	for k, value := range rest {
+		key := strings.ToLower(k)
+		if err := validateKey(key); err != nil {
+			return nil, err
+		}
+		if value == "" {
+			continue
+		}
+		if _, exists := tags[key]; exists {
+			return nil, &TaggedUrnError{
+				Code:    ErrorDuplicateKey,
+				Message: fmt.Sprintf("duplicate tag key: %s", key),
+			}
+		}
+		tags[key] = value
+	}
+
+	return &TaggedUrn{prefix: NormalizePrefix(prefix), tags: tags, literals: codeLiterals(tags)}, nil
+}
+
+// ToStruct writes tag values into the fields of the struct pointed to by v
+// Fields are mapped the same way as FromStruct. Each tag value is converted
+// to the field's type (string, signed/unsigned integer or bool) and a failed
+// conversion is an ErrorInvalidValue; bools accept only true/false/1/0, as
+// in GetBool. Fields whose tag is absent are left untouched,
+// unless tagged `urn:"key,required"` in which case an error is returned.
+// Tags not mapped to any field are ignored, or collected into a
+// map[string]string field tagged `urn:",rest"` when one is present.
+func (c *TaggedUrn) ToStruct(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("ToStruct requires a non-nil pointer to a struct, got %T", v)
+	}
+	rv = rv.Elem()
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("ToStruct requires a non-nil pointer to a struct, got %T", v)
+	}
+
+	mapped := make(map[string]bool)
+	restIndex := -1
+	for _, field := range structFields(rv.Type()) {
+		fv := rv.Field(field.index)
+		if field.rest {
+			if fv.Type() != reflect.TypeOf(map[string]string(nil)) {
+				return fmt.Errorf("field %s: rest field must be map[string]string, got %s", field.name, fv.Type())
+			}
+			restIndex = field.index
+			continue
+		}
+
+		mapped[field.key] = true
+		value, exists := c.tags[field.key]
+		if !exists {
+			if field.required {
+				return fmt.Errorf("field %s: required tag '%s' is missing", field.name, field.key)
+			}
+			continue
+		}
+
+		switch fv.Kind() {
+		case reflect.String:
+			fv.SetString(value)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n, err := strconv.ParseInt(value, 10, fv.Type().Bits())
+			if err != nil {
+				return fieldValueError(field, value, fv.Type(), errors.Unwrap(err))
+			}
+			fv.SetInt(n)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n, err := strconv.ParseUint(value, 10, fv.Type().Bits())
+			if err != nil {
+				return fieldValueError(field, value, fv.Type(), errors.Unwrap(err))
+			}
+			fv.SetUint(n)
+		case reflect.Bool:
+			b, ok := parseBoolValue(value)
+			if !ok {
+				return fieldValueError(field, value, fv.Type(), nil)
+			}
+			fv.SetBool(b)
+		default:
+			return fmt.Errorf("field %s: unsupported type %s for tag '%s'", field.name, fv.Type(), field.key)
+		}
+	}
+
+	if restIndex >= 0 {
+		rest := make(map[string]string)
+		for key, value := range c.tags {
+			if !mapped[key] {
+				rest[key] = value
+			}
+		}
+		rv.Field(restIndex).Set(reflect.ValueOf(rest))
+	}
+	return nil
+}
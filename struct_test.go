@@ -0,0 +1,158 @@
+package taggedurn
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type captureConfig struct {
+	Op       string `urn:"op"`
+	Ext      string `urn:"ext,omitempty"`
+	Quality  int    `urn:"quality,omitempty"`
+	Debug    bool   `urn:"debug"`
+	Target   string
+	internal string
+	Ignored  string `urn:"-"`
+}
+
+func TestFromStruct(t *testing.T) {
+	urn, err := FromStruct("cap", captureConfig{
+		Op:       "generate",
+		Ext:      "pdf",
+		Quality:  90,
+		Target:   "thumbnail",
+		internal: "hidden",
+		Ignored:  "skip",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "cap:debug=false;ext=pdf;op=generate;quality=90;target=thumbnail", urn.ToString())
+}
+
+func TestFromStructOmitEmpty(t *testing.T) {
+	urn, err := FromStruct("cap", &captureConfig{Op: "generate", Debug: true})
+	require.NoError(t, err)
+
+	// ext and quality are omitempty; target is an empty string and always skipped
+	assert.Equal(t, "cap:debug=true;op=generate", urn.ToString())
+}
+
+func TestFromStructPreservesValueCase(t *testing.T) {
+	urn, err := FromStruct("CAP", struct {
+		Name string `urn:"Name"`
+	}{Name: "Hello World"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "cap", urn.GetPrefix())
+	assert.True(t, urn.HasTag("name", "Hello World"))
+	assert.Equal(t, `cap:name="Hello World"`, urn.ToString())
+}
+
+func TestFromStructErrors(t *testing.T) {
+	_, err := FromStruct("cap", "not a struct")
+	assert.Error(t, err)
+
+	_, err = FromStruct("cap", (*captureConfig)(nil))
+	assert.Error(t, err)
+
+	_, err = FromStruct("", captureConfig{Op: "x"})
+	require.Error(t, err)
+	assert.Equal(t, ErrorEmptyPrefix, err.(*TaggedUrnError).Code)
+
+	_, err = FromStruct("cap", struct {
+		Ratio float64
+	}{Ratio: 1.5})
+	assert.Error(t, err)
+
+	_, err = FromStruct("cap", struct {
+		Code string `urn:"123"`
+	}{Code: "x"})
+	require.Error(t, err)
+	assert.Equal(t, ErrorNumericKey, err.(*TaggedUrnError).Code)
+
+	_, err = FromStruct("cap", struct {
+		A string `urn:"key"`
+		B string `urn:"KEY"`
+	}{A: "x", B: "y"})
+	require.Error(t, err)
+	assert.Equal(t, ErrorDuplicateKey, err.(*TaggedUrnError).Code)
+}
+
+type renderTarget struct {
+	Op      string            `urn:"op,required"`
+	Width   int               `urn:"width"`
+	Retries uint8             `urn:"retries"`
+	Debug   bool              `urn:"debug"`
+	Extra   map[string]string `urn:",rest"`
+}
+
+func TestToStruct(t *testing.T) {
+	urn, err := NewTaggedUrnFromString("cap:op=render;width=640;retries=3;debug=true;ext=pdf;draft")
+	require.NoError(t, err)
+
+	var target renderTarget
+	require.NoError(t, urn.ToStruct(&target))
+
+	assert.Equal(t, "render", target.Op)
+	assert.Equal(t, 640, target.Width)
+	assert.Equal(t, uint8(3), target.Retries)
+	assert.True(t, target.Debug)
+	assert.Equal(t, map[string]string{"ext": "pdf", "draft": "*"}, target.Extra)
+}
+
+func TestToStructIgnoresUnmappedTags(t *testing.T) {
+	urn, err := NewTaggedUrnFromString("cap:op=generate;ext=pdf;unknown=x")
+	require.NoError(t, err)
+
+	var cfg captureConfig
+	require.NoError(t, urn.ToStruct(&cfg))
+	assert.Equal(t, "generate", cfg.Op)
+	assert.Equal(t, "pdf", cfg.Ext)
+	assert.Equal(t, 0, cfg.Quality)
+}
+
+func TestToStructErrors(t *testing.T) {
+	urn, err := NewTaggedUrnFromString("cap:op=render;width=wide")
+	require.NoError(t, err)
+
+	var target renderTarget
+	err = urn.ToStruct(&target)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "width")
+	assert.Equal(t, ErrorInvalidValue, err.(*TaggedUrnError).Code)
+
+	// Bools accept only true/false/1/0, as GetBool does
+	for _, value := range []string{"t", "TRUE", "F", "yes"} {
+		flagged, err := NewTaggedUrnFromString(`cap:op=x;debug="` + value + `"`)
+		require.NoError(t, err)
+		err = flagged.ToStruct(&renderTarget{})
+		require.Error(t, err, value)
+		assert.Equal(t, ErrorInvalidValue, err.(*TaggedUrnError).Code, value)
+	}
+
+	missing, err := NewTaggedUrnFromString("cap:width=10")
+	require.NoError(t, err)
+	err = missing.ToStruct(&renderTarget{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "required")
+
+	overflow, err := NewTaggedUrnFromString("cap:op=x;retries=300")
+	require.NoError(t, err)
+	assert.Error(t, overflow.ToStruct(&renderTarget{}))
+
+	assert.Error(t, urn.ToStruct(target))
+	assert.Error(t, urn.ToStruct((*renderTarget)(nil)))
+}
+
+func TestStructRoundTrip(t *testing.T) {
+	original := renderTarget{Op: "render", Width: 320, Retries: 2, Debug: true, Extra: map[string]string{"ext": "png"}}
+	urn, err := FromStruct("cap", original)
+	require.NoError(t, err)
+	assert.Equal(t, "cap:debug=true;ext=png;op=render;retries=2;width=320", urn.ToString())
+
+	var decoded renderTarget
+	require.NoError(t, urn.ToStruct(&decoded))
+	assert.Equal(t, original, decoded)
+}
@@ -16,13 +16,23 @@
 package taggedurn
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
 )
 
 // TaggedUrn represents a tagged URN using flat, ordered tags with a configurable prefix.
@@ -31,9 +41,25 @@ import (
 //   - cap:op=generate;ext=pdf;out=binary;target=thumbnail
 //   - cap:format=*;debug=!  (format required, debug forbidden)
 //   - myapp:key="Value With Spaces"
+//
+// A TaggedUrn is immutable: every method that changes tags (WithTag,
+// WithoutTag, Merge, Tighten and the like) returns a new instance, and
+// accessors such as AllTags return copies. The only in-place write is
+// UnmarshalJSON decoding into its receiver, which a frozen URN refuses (see
+// Freeze).
 type TaggedUrn struct {
 	prefix string
 	tags   map[string]string
+	// explicitWildcards marks "*" tags written as key=* when parsed with
+	// ParseOptions.PreserveWildcardSyntax; it only affects serialization
+	explicitWildcards map[string]bool
+	// literals marks tags whose value would read as pattern syntax (@enum,
+	// ~conditional or /regex/) if unquoted but was quoted when parsed, or is
+	// a "/.../" value set in code; they match as exact strings and are quoted
+	// again on output
+	literals map[string]bool
+	// frozen makes UnmarshalJSON fail instead of overwriting the receiver
+	frozen bool
 }
 
 // TaggedUrnError represents errors that can occur during tagged URN operations
@@ -60,6 +86,20 @@ const (
 	ErrorEmptyPrefix           = 10
 	ErrorPrefixMismatch        = 11
 	ErrorWhitespaceInInput     = 12
+	ErrorIncompatibleTighten   = 13
+	ErrorNotSpecialization     = 14
+	ErrorPatternUsedAsInstance = 15
+	ErrorUnknownEnum           = 16
+	ErrorInvalidRegex          = 17
+	ErrorMissingDomain         = 18
+	ErrorEnumerationLimit      = 19
+	ErrorNotDistinguishable    = 20
+	ErrorUndefinedReference    = 21
+	ErrorReservedPrefix        = 22
+	ErrorFrozenUrn             = 23
+	ErrorUndefinedVariable     = 24
+	ErrorInvalidValue          = 25
+	ErrorNoComplement          = 26
 )
 
 // Parser states for state machine
@@ -82,33 +122,273 @@ func isValidKeyChar(c rune) bool {
 	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '-' || c == '/' || c == ':' || c == '.'
 }
 
+// isStrictKeyChar checks if a character is valid for a key under
+// ParseOptions.StrictKeyChars: letters, digits, '_' and '-' only
+func isStrictKeyChar(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '-'
+}
+
 // isValidUnquotedValueChar checks if a character is valid for an unquoted value
 func isValidUnquotedValueChar(c rune) bool {
 	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '-' || c == '/' || c == ':' || c == '.' || c == '*' || c == '?' || c == '!'
 }
 
+// validateKey applies the parser's key rules to a key supplied outside of parsing
+func validateKey(key string) error {
+	return validateKeyWith(key, ParseOptions{})
+}
+
+// validateKeyWith is validateKey under the key character rules of opts
+func validateKeyWith(key string, opts ParseOptions) error {
+	if key == "" {
+		return &TaggedUrnError{
+			Code:    ErrorEmptyTag,
+			Message: "empty key",
+		}
+	}
+	for pos, c := range key {
+		if !opts.keyChar(c) {
+			return &TaggedUrnError{
+				Code:    ErrorInvalidCharacter,
+				Message: fmt.Sprintf("invalid character '%c' in key at position %d", c, pos),
+			}
+		}
+	}
+	if numericPattern.MatchString(key) {
+		return &TaggedUrnError{
+			Code:    ErrorNumericKey,
+			Message: fmt.Sprintf("tag key cannot be purely numeric: %s", key),
+		}
+	}
+	return nil
+}
+
+// isStructuralChar checks if a character delimits or escapes URN syntax
+// These can never appear unquoted, whatever extra characters are allowed.
+func isStructuralChar(c rune) bool {
+	return c == ';' || c == '=' || c == '"' || c == '\\' || unicode.IsSpace(c)
+}
+
+// containsRune checks if c is one of runes
+func containsRune(runes []rune, c rune) bool {
+	for _, r := range runes {
+		if r == c {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString checks if s is one of strs
+func containsString(strs []string, s string) bool {
+	for _, v := range strs {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
 // needsQuoting checks if a value needs quoting for serialization
+// '@' and '~' are only allowed unquoted as the first character (an enum
+// reference or a conditional value). Regex values are written verbatim
+// unless they contain ';', which forces quotes, so such a value reads back
+// as a literal.
 func needsQuoting(value string) bool {
-	for _, c := range value {
+	return needsQuotingWith(value, nil)
+}
+
+// needsQuotingWith is needsQuoting with extra characters allowed unquoted
+// Only the '@' and '~' rule can be relaxed: structural characters and
+// uppercase are always quoted.
+func needsQuotingWith(value string, extra []rune) bool {
+	if isRegexValue(value) && !strings.ContainsRune(value, ';') {
+		return false
+	}
+	for i, c := range value {
 		if c == ';' || c == '=' || c == '"' || c == '\\' || c == ' ' || unicode.IsUpper(c) {
 			return true
 		}
+		if (c == '@' || c == '~') && i > 0 && !containsRune(extra, c) {
+			return true
+		}
 	}
 	return false
 }
 
 // quoteValue quotes a value for serialization
 func quoteValue(value string) string {
-	var result strings.Builder
-	result.WriteRune('"')
-	for _, c := range value {
-		if c == '"' || c == '\\' {
-			result.WriteRune('\\')
+	return string(appendQuoted(nil, value))
+}
+
+// appendQuoted appends a quoted, escaped value to b
+func appendQuoted(b []byte, value string) []byte {
+	b = append(b, '"')
+	for i := 0; i < len(value); i++ {
+		if value[i] == '"' || value[i] == '\\' {
+			b = append(b, '\\')
+		}
+		b = append(b, value[i])
+	}
+	return append(b, '"')
+}
+
+// appendTag appends a single tag in canonical form to b
+// Special value serialization:
+// - * (must-have-any): serialized as value-less tag (just the key)
+// - ? (unspecified): serialized as key=?
+// - ! (must-not-have): serialized as key=!
+// A literal value is always quoted, so it does not read back as pattern syntax.
+func appendTag(b []byte, key, value string, literal bool) []byte {
+	return appendTagWith(b, key, value, literal, nil)
+}
+
+// appendTagWith is appendTag with extra characters allowed in unquoted values
+func appendTagWith(b []byte, key, value string, literal bool, extra []rune) []byte {
+	b = append(b, key...)
+	switch value {
+	case "*":
+		// Valueless sugar: key
+		return b
+	case "?", "!":
+		// Explicit: key=? / key=!
+		b = append(b, '=')
+		return append(b, value...)
+	default:
+		b = append(b, '=')
+		if literal || needsQuotingWith(value, extra) {
+			return appendQuoted(b, value)
+		}
+		return append(b, value...)
+	}
+}
+
+// DuplicateKeyPolicy controls how the parser handles a key that appears more than once
+type DuplicateKeyPolicy int
+
+const (
+	// DuplicateKeyError rejects duplicate keys with ErrorDuplicateKey (default)
+	DuplicateKeyError DuplicateKeyPolicy = iota
+	// DuplicateKeyLastWins keeps the value of the last occurrence
+	DuplicateKeyLastWins
+	// DuplicateKeyFirstWins keeps the value of the first occurrence
+	DuplicateKeyFirstWins
+)
+
+// ParseOptions adjusts how tagged URN strings are parsed
+// The zero value gives the default, strict behavior of NewTaggedUrnFromString.
+type ParseOptions struct {
+	// NormalizeUnicode applies Unicode NFC normalization to keys and values,
+	// so composed and decomposed forms of the same text compare equal.
+	// Uses golang.org/x/text/unicode/norm. Default off for backward compatibility.
+	NormalizeUnicode bool
+
+	// AllowTrailingComment ignores everything from the first unquoted '#' to
+	// the end of the input, along with any whitespace before it. A '#' inside
+	// a quoted value stays literal. Default off, since '#' is otherwise an
+	// invalid character.
+	AllowTrailingComment bool
+
+	// DuplicateKeyPolicy decides what happens when a key repeats
+	// The canonical form always holds a single entry per key.
+	DuplicateKeyPolicy DuplicateKeyPolicy
+
+	// EmptyValueAsWildcard parses an unquoted empty value (key= at the end or
+	// key=; mid-string) as key=* (must-have-any) instead of ErrorEmptyTag.
+	// An explicitly quoted empty value (key="") is still an error.
+	EmptyValueAsWildcard bool
+
+	// ExtraKeyChars and ExtraValueChars extend the characters allowed in keys
+	// and unquoted values (e.g. '@' and '+' for email-like values).
+	// Structural characters (';', '=', '"', '\\' and whitespace) cannot be
+	// added. Keys are never quoted, so ExtraKeyChars may only hold characters
+	// valid in keys by default (such as '.' under StrictKeyChars); anything
+	// else is an ErrorInvalidFormat, as ToString could not write it back. Use
+	// SerializeOptions.ExtraValueChars to write values with '@' or '~' back
+	// unquoted.
+	ExtraKeyChars   []rune
+	ExtraValueChars []rune
+
+	// ForceLowercaseValues lowercases quoted values too, so cap:key="PDF"
+	// stores pdf and equals cap:key=pdf. Regex values keep their case, since
+	// it is significant to the expression. Default off: quotes preserve case.
+	ForceLowercaseValues bool
+
+	// StrictKeyChars restricts keys to letters, digits, '_' and '-', so
+	// path-like keys such as a/b, a.b or a:b are an ErrorInvalidCharacter.
+	// ExtraKeyChars still apply on top. Default off, keeping '/', ':' and
+	// '.' valid in keys.
+	StrictKeyChars bool
+
+	// PreserveWildcardSyntax remembers which must-have-any tags were written
+	// as key=* rather than the value-less shorthand, and ToString re-emits
+	// them that way. Equality, Hash and matching ignore the distinction.
+	// URNs derived through WithTag, Merge and the like use the shorthand.
+	PreserveWildcardSyntax bool
+
+	// ExpandReferences lets an unquoted value of the form $key stand for the
+	// value of an earlier tag, so cap:src=pdf;dst=$src stores dst=pdf.
+	// References resolve in a single left-to-right pass: a key that is not
+	// yet defined (including a forward or self reference, so cycles cannot
+	// form) is an ErrorUndefinedReference. A quoted "$key" stays literal.
+	// Default off, leaving '$' an invalid character.
+	ExpandReferences bool
+
+	// Lookup, when set, expands ${VAR} in unquoted values with the looked-up
+	// text, e.g. Lookup: os.LookupEnv for cap:region=${REGION}. ${VAR:-def}
+	// falls back to def when VAR is undefined; otherwise an undefined VAR is
+	// an ErrorUndefinedVariable. The expansion is treated as part of the
+	// unquoted value, so it is lowercased and must only contain characters
+	// valid there. A quoted "${VAR}" stays literal. Default nil (off).
+	Lookup func(name string) (string, bool)
+}
+
+// NormalizePrefix applies the prefix normalization used by every constructor
+// Prefixes are case-insensitive and stored lowercased.
+func NormalizePrefix(prefix string) string {
+	return strings.ToLower(prefix)
+}
+
+// reservedPrefixes holds the prefixes registered with RegisterReservedPrefix
+var reservedPrefixes = struct {
+	sync.RWMutex
+	prefixes map[string]bool
+}{prefixes: make(map[string]bool)}
+
+// RegisterReservedPrefix forbids a prefix (e.g. one colliding with another
+// URI scheme such as http or urn)
+// Parsing, Single, FromStruct and TaggedUrnBuilder.Build then return
+// ErrorReservedPrefix for it. Constructors that cannot fail
+// (NewTaggedUrnFromTags, Empty and BuildAllowEmpty) do not check, and URNs
+// derived from existing ones (WithTag, Merge and the like) keep their prefix
+// unchecked. The prefix is normalized like any other. No prefix is reserved
+// by default.
+func RegisterReservedPrefix(prefix string) {
+	reservedPrefixes.Lock()
+	defer reservedPrefixes.Unlock()
+	reservedPrefixes.prefixes[NormalizePrefix(prefix)] = true
+}
+
+// UnregisterReservedPrefix allows a prefix reserved by RegisterReservedPrefix
+// again. Unreserving a prefix that is not reserved does nothing.
+func UnregisterReservedPrefix(prefix string) {
+	reservedPrefixes.Lock()
+	defer reservedPrefixes.Unlock()
+	delete(reservedPrefixes.prefixes, NormalizePrefix(prefix))
+}
+
+// checkReservedPrefix returns ErrorReservedPrefix if the normalized prefix
+// has been reserved
+func checkReservedPrefix(prefix string) error {
+	reservedPrefixes.RLock()
+	defer reservedPrefixes.RUnlock()
+	if reservedPrefixes.prefixes[prefix] {
+		return &TaggedUrnError{
+			Code:    ErrorReservedPrefix,
+			Message: fmt.Sprintf("prefix '%s' is reserved", prefix),
 		}
-		result.WriteRune(c)
 	}
-	result.WriteRune('"')
-	return result.String()
+	return nil
 }
 
 // NewTaggedUrnFromString creates a tagged URN from a string
@@ -123,6 +403,100 @@ func quoteValue(value string) string {
 // - Unquoted values: Normalized to lowercase
 // - Quoted values: Case preserved exactly as specified
 func NewTaggedUrnFromString(s string) (*TaggedUrn, error) {
+	return ParseWithOptions(s, ParseOptions{})
+}
+
+// NewTaggedUrnWithPrefix creates a tagged URN from a string that must use the expected prefix
+// The prefix comparison is case-insensitive; a different prefix returns
+// ErrorPrefixMismatch. Parse errors are returned unchanged.
+func NewTaggedUrnWithPrefix(expectedPrefix, s string) (*TaggedUrn, error) {
+	urn, err := NewTaggedUrnFromString(s)
+	if err != nil {
+		return nil, err
+	}
+	expected := NormalizePrefix(expectedPrefix)
+	if urn.prefix != expected {
+		return nil, &TaggedUrnError{
+			Code:    ErrorPrefixMismatch,
+			Message: fmt.Sprintf("expected prefix '%s', got '%s'", expected, urn.prefix),
+		}
+	}
+	return urn, nil
+}
+
+// IsCanonical reports whether s is already in canonical form, i.e. equal to
+// the ToString of the URN it parses to (sorted, lowercased and minimally
+// quoted, with no trailing ';' or explicit key=*)
+// It returns the parse error if s is not a valid URN.
+func IsCanonical(s string) (bool, error) {
+	urn, err := NewTaggedUrnFromString(s)
+	if err != nil {
+		return false, err
+	}
+	return s == urn.ToString(), nil
+}
+
+// checkExtraChars rejects structural characters in ExtraKeyChars and
+// ExtraValueChars, and extra key characters the default key rules refuse
+func (o ParseOptions) checkExtraChars() error {
+	for _, c := range append(append([]rune(nil), o.ExtraKeyChars...), o.ExtraValueChars...) {
+		if isStructuralChar(c) {
+			return &TaggedUrnError{
+				Code:    ErrorInvalidFormat,
+				Message: fmt.Sprintf("structural character %q cannot be allowed as an extra character", c),
+			}
+		}
+	}
+	for _, c := range o.ExtraKeyChars {
+		if !isValidKeyChar(c) {
+			return &TaggedUrnError{
+				Code:    ErrorInvalidFormat,
+				Message: fmt.Sprintf("extra key character %q would not survive canonical output", c),
+			}
+		}
+	}
+	return nil
+}
+
+// keyChar reports whether c may appear in a key under these options
+func (o ParseOptions) keyChar(c rune) bool {
+	if containsRune(o.ExtraKeyChars, c) {
+		return true
+	}
+	if o.StrictKeyChars {
+		return isStrictKeyChar(c)
+	}
+	return isValidKeyChar(c)
+}
+
+// ParseWithOptions creates a tagged URN from a string using the given parse options
+// With zero-valued options it is identical to NewTaggedUrnFromString.
+func ParseWithOptions(s string, opts ParseOptions) (*TaggedUrn, error) {
+	return parse(s, opts, nil, nil)
+}
+
+// parse is the parser state machine behind every parsing entry point
+// When warn is non-nil it is called for each non-fatal normalization; when
+// token is non-nil it is called for each token in source order.
+func parse(s string, opts ParseOptions, warn func(Warning), token func(Token)) (*TaggedUrn, error) {
+	addWarning := func(code, position int, format string, args ...interface{}) {
+		if warn != nil {
+			warn(Warning{Code: code, Message: fmt.Sprintf(format, args...), Position: position})
+		}
+	}
+
+	if err := opts.checkExtraChars(); err != nil {
+		return nil, err
+	}
+	keyChar := opts.keyChar
+	valueChar := func(c rune) bool {
+		return isValidUnquotedValueChar(c) || containsRune(opts.ExtraValueChars, c)
+	}
+
+	if opts.AllowTrailingComment {
+		s = stripTrailingComment(s)
+	}
+
 	// Fail hard on leading/trailing whitespace
 	if s != strings.TrimSpace(s) {
 		return nil, &TaggedUrnError{
@@ -154,20 +528,48 @@ func NewTaggedUrnFromString(s string) (*TaggedUrn, error) {
 		}
 	}
 
-	prefix := strings.ToLower(s[:colonPos])
+	prefix := NormalizePrefix(s[:colonPos])
+	if err := checkReservedPrefix(prefix); err != nil {
+		return nil, err
+	}
+	if prefix != s[:colonPos] {
+		addWarning(WarningLowercasedPrefix, 0, "prefix '%s' was lowercased to '%s'", s[:colonPos], prefix)
+	}
 	tagsPart := s[colonPos+1:]
 	tags := make(map[string]string)
+	// offset converts a rune position in tagsPart to one in s
+	offset := utf8.RuneCountInString(s[:colonPos+1])
+	chars := []rune(tagsPart)
+
+	emitToken := func(kind TokenKind, start, end int) {
+		if token != nil {
+			token(Token{Kind: kind, Value: string(chars[start:end]), Start: offset + start, End: offset + end})
+		}
+	}
+	if token != nil {
+		token(Token{Kind: TokenPrefix, Value: s[:colonPos], Start: 0, End: offset - 1})
+	}
 
 	// Handle empty tagged URN (prefix: with no tags or just semicolon)
 	if tagsPart == "" || tagsPart == ";" {
+		if tagsPart == ";" {
+			emitToken(TokenSemicolon, 0, 1)
+			addWarning(WarningTrailingSemicolon, offset, "redundant trailing ';'")
+		}
 		return &TaggedUrn{prefix: prefix, tags: tags}, nil
 	}
 
 	state := stateExpectingKey
 	var currentKey strings.Builder
 	var currentValue strings.Builder
-	chars := []rune(tagsPart)
 	pos := 0
+	var explicitWildcards map[string]bool
+	var literals map[string]bool
+	valueless := false
+	quoted := false
+	reference := false
+	keyStart, valueStart, quoteStart := 0, 0, 0
+	keyLowered, valueLowered := false, false
 
 	finishTag := func() error {
 		key := currentKey.String()
@@ -186,12 +588,39 @@ func NewTaggedUrnFromString(s string) (*TaggedUrn, error) {
 			}
 		}
 
+		literal := quoted && hasPatternSyntax(value)
+		if reference {
+			literal = literals[value[1:]]
+			referenced, exists := tags[value[1:]]
+			if !exists {
+				return &TaggedUrnError{
+					Code:    ErrorUndefinedReference,
+					Message: fmt.Sprintf("tag '%s' references undefined tag '%s'", key, value[1:]),
+				}
+			}
+			value = referenced
+			reference = false
+		}
+
+		if opts.ForceLowercaseValues && (literal || !isRegexValue(value)) {
+			value = strings.ToLower(value)
+		}
+
+		if opts.NormalizeUnicode {
+			key = norm.NFC.String(key)
+			value = norm.NFC.String(value)
+		}
+
 		// Check for duplicate keys
+		duplicate := false
 		if _, exists := tags[key]; exists {
-			return &TaggedUrnError{
-				Code:    ErrorDuplicateKey,
-				Message: fmt.Sprintf("duplicate tag key: %s", key),
+			if opts.DuplicateKeyPolicy == DuplicateKeyError {
+				return &TaggedUrnError{
+					Code:    ErrorDuplicateKey,
+					Message: fmt.Sprintf("duplicate tag key: %s", key),
+				}
 			}
+			duplicate = true
 		}
 
 		// Validate key cannot be purely numeric
@@ -202,12 +631,88 @@ func NewTaggedUrnFromString(s string) (*TaggedUrn, error) {
 			}
 		}
 
-		tags[key] = value
+		if !duplicate || opts.DuplicateKeyPolicy == DuplicateKeyLastWins {
+			tags[key] = value
+			if opts.PreserveWildcardSyntax {
+				if value == "*" && !valueless {
+					if explicitWildcards == nil {
+						explicitWildcards = make(map[string]bool)
+					}
+					explicitWildcards[key] = true
+				} else {
+					delete(explicitWildcards, key)
+				}
+			}
+			if literal {
+				if literals == nil {
+					literals = make(map[string]bool)
+				}
+				literals[key] = true
+			} else {
+				delete(literals, key)
+			}
+		}
+		valueless = false
+		quoted = false
 		currentKey.Reset()
 		currentValue.Reset()
 		return nil
 	}
 
+	// expandVariable consumes a ${VAR} or ${VAR:-def} starting at pos into
+	// currentValue, leaving pos on the closing '}'
+	expandVariable := func() error {
+		end := pos + 2
+		for end < len(chars) && chars[end] != '}' {
+			end++
+		}
+		if end == len(chars) {
+			return &TaggedUrnError{
+				Code:    ErrorInvalidFormat,
+				Message: fmt.Sprintf("unterminated variable reference at position %d", pos),
+			}
+		}
+		name, fallback, hasFallback := strings.Cut(string(chars[pos+2:end]), ":-")
+		if name == "" {
+			return &TaggedUrnError{
+				Code:    ErrorInvalidFormat,
+				Message: fmt.Sprintf("empty variable name at position %d", pos),
+			}
+		}
+		for _, r := range name {
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+				return &TaggedUrnError{
+					Code:    ErrorInvalidCharacter,
+					Message: fmt.Sprintf("invalid character '%c' in variable name at position %d", r, pos),
+				}
+			}
+		}
+		expansion, defined := opts.Lookup(name)
+		if !defined {
+			if !hasFallback {
+				return &TaggedUrnError{
+					Code:    ErrorUndefinedVariable,
+					Message: fmt.Sprintf("variable '%s' is not defined", name),
+				}
+			}
+			expansion = fallback
+		}
+		for _, r := range expansion {
+			if !valueChar(r) {
+				return &TaggedUrnError{
+					Code:    ErrorInvalidCharacter,
+					Message: fmt.Sprintf("invalid character '%c' in expansion of variable '%s'", r, name),
+				}
+			}
+			currentValue.WriteRune(unicode.ToLower(r))
+		}
+		pos = end
+		return nil
+	}
+	isVariable := func() bool {
+		return opts.Lookup != nil && chars[pos] == '$' && pos+1 < len(chars) && chars[pos+1] == '{'
+	}
+
 	for pos < len(chars) {
 		c := chars[pos]
 
@@ -215,9 +720,15 @@ func NewTaggedUrnFromString(s string) (*TaggedUrn, error) {
 		case stateExpectingKey:
 			if c == ';' {
 				// Empty segment, skip
+				emitToken(TokenSemicolon, pos, pos+1)
 				pos++
 				continue
-			} else if isValidKeyChar(c) {
+			} else if keyChar(c) {
+				keyStart, keyLowered = pos, false
+				if unicode.IsUpper(c) {
+					keyLowered = true
+					addWarning(WarningLowercasedKey, offset+pos, "key at position %d was lowercased", offset+pos)
+				}
 				currentKey.WriteRune(unicode.ToLower(c))
 				state = stateInKey
 			} else {
@@ -235,6 +746,8 @@ func NewTaggedUrnFromString(s string) (*TaggedUrn, error) {
 						Message: "empty key",
 					}
 				}
+				emitToken(TokenKey, keyStart, pos)
+				emitToken(TokenEquals, pos, pos+1)
 				state = stateExpectingValue
 			} else if c == ';' {
 				// Value-less tag: treat as wildcard
@@ -244,12 +757,20 @@ func NewTaggedUrnFromString(s string) (*TaggedUrn, error) {
 						Message: "empty key",
 					}
 				}
+				emitToken(TokenKey, keyStart, pos)
+				emitToken(TokenSemicolon, pos, pos+1)
+				addWarning(WarningValuelessTag, offset+keyStart, "value-less tag '%s' became %s=*", currentKey.String(), currentKey.String())
 				currentValue.WriteString("*")
+				valueless = true
 				if err := finishTag(); err != nil {
 					return nil, err
 				}
 				state = stateExpectingKey
-			} else if isValidKeyChar(c) {
+			} else if keyChar(c) {
+				if unicode.IsUpper(c) && !keyLowered {
+					keyLowered = true
+					addWarning(WarningLowercasedKey, offset+keyStart, "key at position %d was lowercased", offset+keyStart)
+				}
 				currentKey.WriteRune(unicode.ToLower(c))
 			} else {
 				return nil, &TaggedUrnError{
@@ -259,14 +780,55 @@ func NewTaggedUrnFromString(s string) (*TaggedUrn, error) {
 			}
 
 		case stateExpectingValue:
+			if c == '/' {
+				// A "/.../" regex runs verbatim (case preserved) to the next ';'
+				end := pos
+				for end < len(chars) && chars[end] != ';' {
+					end++
+				}
+				if candidate := string(chars[pos:end]); isRegexValue(candidate) {
+					emitToken(TokenValue, pos, end)
+					currentValue.WriteString(candidate)
+					pos = end
+					state = stateExpectingSemiOrEnd
+					continue
+				}
+			}
 			if c == '"' {
+				emitToken(TokenQuote, pos, pos+1)
+				quoteStart = pos + 1
+				quoted = true
 				state = stateInQuotedValue
+			} else if isVariable() {
+				valueStart, valueLowered = pos, false
+				if err := expandVariable(); err != nil {
+					return nil, err
+				}
+				state = stateInUnquotedValue
 			} else if c == ';' {
-				return nil, &TaggedUrnError{
-					Code:    ErrorEmptyTag,
-					Message: fmt.Sprintf("empty value for key '%s'", currentKey.String()),
+				if !opts.EmptyValueAsWildcard {
+					return nil, &TaggedUrnError{
+						Code:    ErrorEmptyTag,
+						Message: fmt.Sprintf("empty value for key '%s'", currentKey.String()),
+					}
+				}
+				emitToken(TokenSemicolon, pos, pos+1)
+				addWarning(WarningValuelessTag, offset+keyStart, "empty value for '%s' became %s=*", currentKey.String(), currentKey.String())
+				currentValue.WriteString("*")
+				valueless = true
+				if err := finishTag(); err != nil {
+					return nil, err
+				}
+				state = stateExpectingKey
+			} else if valueChar(c) || c == '@' || c == '~' || (c == '$' && opts.ExpandReferences) {
+				// '@', '~' and '$' may only start a value (enum reference,
+				// conditional, tag reference)
+				reference = c == '$' && opts.ExpandReferences
+				valueStart, valueLowered = pos, false
+				if unicode.IsUpper(c) {
+					valueLowered = true
+					addWarning(WarningLowercasedValue, offset+pos, "value for '%s' was lowercased; quote it to preserve case", currentKey.String())
 				}
-			} else if isValidUnquotedValueChar(c) {
 				currentValue.WriteRune(unicode.ToLower(c))
 				state = stateInUnquotedValue
 			} else {
@@ -278,11 +840,21 @@ func NewTaggedUrnFromString(s string) (*TaggedUrn, error) {
 
 		case stateInUnquotedValue:
 			if c == ';' {
+				emitToken(TokenValue, valueStart, pos)
+				emitToken(TokenSemicolon, pos, pos+1)
 				if err := finishTag(); err != nil {
 					return nil, err
 				}
 				state = stateExpectingKey
-			} else if isValidUnquotedValueChar(c) {
+			} else if isVariable() {
+				if err := expandVariable(); err != nil {
+					return nil, err
+				}
+			} else if valueChar(c) {
+				if unicode.IsUpper(c) && !valueLowered {
+					valueLowered = true
+					addWarning(WarningLowercasedValue, offset+valueStart, "value for '%s' was lowercased; quote it to preserve case", currentKey.String())
+				}
 				currentValue.WriteRune(unicode.ToLower(c))
 			} else {
 				return nil, &TaggedUrnError{
@@ -293,6 +865,8 @@ func NewTaggedUrnFromString(s string) (*TaggedUrn, error) {
 
 		case stateInQuotedValue:
 			if c == '"' {
+				emitToken(TokenValue, quoteStart, pos)
+				emitToken(TokenQuote, pos, pos+1)
 				state = stateExpectingSemiOrEnd
 			} else if c == '\\' {
 				state = stateInQuotedValueEscape
@@ -314,6 +888,7 @@ func NewTaggedUrnFromString(s string) (*TaggedUrn, error) {
 
 		case stateExpectingSemiOrEnd:
 			if c == ';' {
+				emitToken(TokenSemicolon, pos, pos+1)
 				if err := finishTag(); err != nil {
 					return nil, err
 				}
@@ -332,11 +907,17 @@ func NewTaggedUrnFromString(s string) (*TaggedUrn, error) {
 	// Handle end of input
 	switch state {
 	case stateInUnquotedValue, stateExpectingSemiOrEnd:
+		if state == stateInUnquotedValue {
+			emitToken(TokenValue, valueStart, len(chars))
+		}
 		if err := finishTag(); err != nil {
 			return nil, err
 		}
 	case stateExpectingKey:
 		// Valid - trailing semicolon or empty input after prefix
+		if len(chars) > 0 && chars[len(chars)-1] == ';' {
+			addWarning(WarningTrailingSemicolon, offset+len(chars)-1, "redundant trailing ';'")
+		}
 	case stateInQuotedValue, stateInQuotedValueEscape:
 		return nil, &TaggedUrnError{
 			Code:    ErrorUnterminatedQuote,
@@ -350,33 +931,186 @@ func NewTaggedUrnFromString(s string) (*TaggedUrn, error) {
 				Message: "empty key",
 			}
 		}
+		emitToken(TokenKey, keyStart, len(chars))
+		addWarning(WarningValuelessTag, offset+keyStart, "value-less tag '%s' became %s=*", currentKey.String(), currentKey.String())
 		currentValue.WriteString("*")
+		valueless = true
 		if err := finishTag(); err != nil {
 			return nil, err
 		}
 	case stateExpectingValue:
-		return nil, &TaggedUrnError{
-			Code:    ErrorEmptyTag,
-			Message: fmt.Sprintf("empty value for key '%s'", currentKey.String()),
+		if !opts.EmptyValueAsWildcard {
+			return nil, &TaggedUrnError{
+				Code:    ErrorEmptyTag,
+				Message: fmt.Sprintf("empty value for key '%s'", currentKey.String()),
+			}
+		}
+		addWarning(WarningValuelessTag, offset+keyStart, "empty value for '%s' became %s=*", currentKey.String(), currentKey.String())
+		currentValue.WriteString("*")
+		valueless = true
+		if err := finishTag(); err != nil {
+			return nil, err
 		}
 	}
 
-	return &TaggedUrn{prefix: prefix, tags: tags}, nil
+	return &TaggedUrn{prefix: prefix, tags: tags, explicitWildcards: explicitWildcards, literals: literals}, nil
+}
+
+// stripTrailingComment removes an unquoted '#' comment and the whitespace before it
+// As in joinLines, a quote only opens a value directly after '='. A '#'
+// inside an unquoted /regex/ value is part of the regex, which ends at a '/'
+// followed by ';', whitespace, '#' or the end of s.
+func stripTrailingComment(s string) string {
+	inQuotes := false
+	escaped := false
+	atComment := func(rest string) bool { return rest[0] == ' ' || rest[0] == '\t' || rest[0] == '#' }
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inQuotes && c == '\\':
+			escaped = true
+		case inQuotes && c == '"':
+			inQuotes = false
+		case !inQuotes && c == '"' && i > 0 && s[i-1] == '=':
+			inQuotes = true
+		case !inQuotes && c == '/' && i > 0 && s[i-1] == '=':
+			if end := regexValueEnd(s, i, atComment); end > i {
+				i = end - 1
+			}
+		case !inQuotes && c == '#':
+			return strings.TrimRight(s[:i], " \t")
+		}
+	}
+	return s
+}
+
+// ParseLenient parses s like NewTaggedUrnFromString but salvages the valid
+// tags of a messy input instead of failing as a whole
+// Tags are split on ';' outside quoted values and each is parsed on its own;
+// a malformed tag is skipped and its error collected, as is a repeated key
+// (the first occurrence is kept). The prefix must still be valid: if it is
+// not, the URN is nil and the single error says why. The error slice is nil
+// when every tag was valid.
+func ParseLenient(s string) (*TaggedUrn, []error) {
+	colonPos := strings.Index(s, ":")
+	if colonPos == -1 {
+		_, err := NewTaggedUrnFromString(s)
+		return nil, []error{err}
+	}
+	empty, err := NewTaggedUrnFromString(s[:colonPos+1])
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	tags := make(map[string]string)
+	var literals map[string]bool
+	var errs []error
+	for _, segment := range splitUnquoted(s[colonPos+1:], ";") {
+		if segment == "" {
+			continue
+		}
+		single, err := NewTaggedUrnFromString(s[:colonPos+1] + segment)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("tag '%s': %w", segment, err))
+			continue
+		}
+		for key, value := range single.tags {
+			if _, exists := tags[key]; exists {
+				errs = append(errs, fmt.Errorf("tag '%s': %w", segment, &TaggedUrnError{
+					Code:    ErrorDuplicateKey,
+					Message: fmt.Sprintf("duplicate tag key: %s", key),
+				}))
+				continue
+			}
+			tags[key] = value
+			literals = setLiteral(literals, key, single.literals[key])
+		}
+	}
+	return &TaggedUrn{prefix: empty.prefix, tags: tags, literals: literals}, errs
+}
+
+// ParseMultiline parses the one-tag-per-line form produced by ToStringMultiline
+// Line breaks (\n or \r\n) outside quoted values are removed and the
+// result is parsed like NewTaggedUrnFromString, so the canonical
+// single-line form is accepted too.
+func ParseMultiline(s string) (*TaggedUrn, error) {
+	return NewTaggedUrnFromString(joinLines(s))
+}
+
+// joinLines removes line breaks that are not inside a quoted value
+// A quote only opens a value directly after '=', so a '"' inside a /regex/
+// does not hide the line breaks that follow it.
+func joinLines(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	inQuotes := false
+	escaped := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inQuotes && c == '\\':
+			escaped = true
+		case inQuotes && c == '"':
+			inQuotes = false
+		case !inQuotes && c == '"' && i > 0 && s[i-1] == '=':
+			inQuotes = true
+		case !inQuotes && c == '\r' && i+1 < len(s) && s[i+1] == '\n':
+			continue
+		case !inQuotes && c == '\n':
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
 }
 
 // NewTaggedUrnFromTags creates a tagged URN from tags with a specified prefix (required)
-// Keys are normalized to lowercase; values are preserved as-is
+// Keys are normalized to lowercase; values are preserved as-is, and a
+// "/.../" value stays a literal (see WithRegexTag). Nothing is validated,
+// not even a prefix reserved with RegisterReservedPrefix.
 func NewTaggedUrnFromTags(prefix string, tags map[string]string) *TaggedUrn {
 	result := make(map[string]string)
 	for k, v := range tags {
 		result[strings.ToLower(k)] = v
 	}
-	return &TaggedUrn{prefix: strings.ToLower(prefix), tags: result}
+	return &TaggedUrn{prefix: NormalizePrefix(prefix), tags: result, literals: codeLiterals(result)}
 }
 
 // Empty creates an empty tagged URN with the specified prefix (required)
+// Like NewTaggedUrnFromTags it does not check for a reserved prefix.
 func Empty(prefix string) *TaggedUrn {
-	return &TaggedUrn{prefix: strings.ToLower(prefix), tags: make(map[string]string)}
+	return &TaggedUrn{prefix: NormalizePrefix(prefix), tags: make(map[string]string)}
+}
+
+// Single creates a validated tagged URN with exactly one tag
+// Prefix and key are normalized to lowercase; the key is validated like the
+// parser does and the value is preserved as-is but must not be empty.
+func Single(prefix, key, value string) (*TaggedUrn, error) {
+	if prefix == "" {
+		return nil, &TaggedUrnError{
+			Code:    ErrorEmptyPrefix,
+			Message: "tagged URN prefix cannot be empty",
+		}
+	}
+	if err := checkReservedPrefix(NormalizePrefix(prefix)); err != nil {
+		return nil, err
+	}
+	key = strings.ToLower(key)
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+	if value == "" {
+		return nil, &TaggedUrnError{
+			Code:    ErrorEmptyTag,
+			Message: fmt.Sprintf("empty value for key '%s' (use '*' for wildcard)", key),
+		}
+	}
+	tags := map[string]string{key: value}
+	return &TaggedUrn{prefix: NormalizePrefix(prefix), tags: tags, literals: codeLiterals(tags)}, nil
 }
 
 // GetPrefix returns the prefix of this tagged URN
@@ -384,6 +1118,11 @@ func (c *TaggedUrn) GetPrefix() string {
 	return c.prefix
 }
 
+// HasPrefix checks if this URN's prefix equals prefix once normalized
+func (c *TaggedUrn) HasPrefix(prefix string) bool {
+	return c.prefix == NormalizePrefix(prefix)
+}
+
 // GetTag returns the value of a specific tag
 // Key is normalized to lowercase for lookup
 func (c *TaggedUrn) GetTag(key string) (string, bool) {
@@ -400,35 +1139,277 @@ func (c *TaggedUrn) AllTags() map[string]string {
 	return result
 }
 
-// HasTag checks if this URN has a specific tag with a specific value
-// Key is normalized to lowercase; value comparison is case-sensitive
-func (c *TaggedUrn) HasTag(key, value string) bool {
-	tagValue, exists := c.tags[strings.ToLower(key)]
-	return exists && tagValue == value
+// AllTagsSorted returns all tags as key/value pairs in canonical (alphabetical) order
+// This is the same ordering ToString uses.
+func (c *TaggedUrn) AllTagsSorted() [][2]string {
+	keys := c.sortedKeys()
+	result := make([][2]string, len(keys))
+	for i, key := range keys {
+		result[i] = [2]string{key, c.tags[key]}
+	}
+	return result
 }
 
-// WithTag returns a new tagged URN with an added or updated tag
-// Key is normalized to lowercase; value is preserved as-is
-func (c *TaggedUrn) WithTag(key, value string) *TaggedUrn {
-	newTags := make(map[string]string)
-	for k, v := range c.tags {
-		newTags[k] = v
+// BoolTag interprets a tag as a boolean flag
+// "true"/"1" and "false"/"0" give that value with set=true. A value-less tag
+// (*) is a presence constraint rather than a value, so it reports true with
+// set=false; a caller testing only value treats `debug` as on, while one
+// testing set sees that no concrete value was given. Absent tags and any
+// other value (including ! and ?) give false, false.
+func (c *TaggedUrn) BoolTag(key string) (value bool, set bool) {
+	raw := c.tags[strings.ToLower(key)]
+	if raw == "*" {
+		return true, false
+	}
+	return parseBoolValue(raw)
+}
+
+// parseBoolValue accepts the flag spellings shared by BoolTag and GetBool
+func parseBoolValue(value string) (bool, bool) {
+	switch value {
+	case "true", "1":
+		return true, true
+	case "false", "0":
+		return false, true
+	default:
+		return false, false
 	}
-	newTags[strings.ToLower(key)] = value
-	return &TaggedUrn{prefix: c.prefix, tags: newTags}
 }
 
-// WithoutTag returns a new tagged URN with a tag removed
-// Key is normalized to lowercase for case-insensitive removal
-func (c *TaggedUrn) WithoutTag(key string) *TaggedUrn {
-	newTags := make(map[string]string)
+// typedValueError reports a tag whose value cannot be read as the wanted type
+func typedValueError(key, value, typeName string, cause error) error {
+	message := fmt.Sprintf("tag '%s' value '%s' is not a valid %s", key, value, typeName)
+	if cause != nil {
+		message += ": " + cause.Error()
+	}
+	return &TaggedUrnError{Code: ErrorInvalidValue, Message: message}
+}
+
+// GetInt returns a tag's value parsed as a base-10 int
+// An absent tag gives (0, false, nil); a present value that is not an
+// integer, including the special values *, ! and ?, gives ErrorInvalidValue.
+func (c *TaggedUrn) GetInt(key string) (int, bool, error) {
+	key = strings.ToLower(key)
+	value, exists := c.tags[key]
+	if !exists {
+		return 0, false, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, true, typedValueError(key, value, "int", errors.Unwrap(err))
+	}
+	return n, true, nil
+}
+
+// GetBool returns a tag's value parsed as a flag ("true"/"1" or "false"/"0",
+// as written by TaggedUrnBuilder.Flag)
+// An absent tag gives (false, false, nil); any other present value,
+// including the special values *, ! and ?, gives ErrorInvalidValue. Use
+// BoolTag to treat a value-less tag as on.
+func (c *TaggedUrn) GetBool(key string) (bool, bool, error) {
+	key = strings.ToLower(key)
+	value, exists := c.tags[key]
+	if !exists {
+		return false, false, nil
+	}
+	b, ok := parseBoolValue(value)
+	if !ok {
+		return false, true, typedValueError(key, value, "bool", nil)
+	}
+	return b, true, nil
+}
+
+// GetDuration returns a tag's value parsed with time.ParseDuration (e.g. 90s,
+// 1h30m)
+// An absent tag gives (0, false, nil); a present value that is not a
+// duration, including the special values *, ! and ?, gives ErrorInvalidValue.
+func (c *TaggedUrn) GetDuration(key string) (time.Duration, bool, error) {
+	key = strings.ToLower(key)
+	value, exists := c.tags[key]
+	if !exists {
+		return 0, false, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, true, typedValueError(key, value, "duration", nil)
+	}
+	return d, true, nil
+}
+
+// IsEmpty checks if this URN has no tags
+// An empty pattern matches every instance with the same prefix.
+func (c *TaggedUrn) IsEmpty() bool {
+	return len(c.tags) == 0
+}
+
+// HasTag checks if this URN has a specific tag with a specific value
+// Key is normalized to lowercase; value comparison is case-sensitive
+func (c *TaggedUrn) HasTag(key, value string) bool {
+	tagValue, exists := c.tags[strings.ToLower(key)]
+	return exists && tagValue == value
+}
+
+// IsConcreteInstance checks if every tag holds an exact value
+// A concrete instance contains no *, !, ?, @enum, ~conditional or /regex/ constraints.
+// An empty URN is trivially concrete.
+func (c *TaggedUrn) IsConcreteInstance() bool {
+	for key := range c.tags {
+		if c.kindOf(key) != KindExact {
+			return false
+		}
+	}
+	return true
+}
+
+// IsPattern checks if at least one tag holds a pattern constraint (*, !, ?, @enum, ~v or /re/)
+// It is the negation of IsConcreteInstance.
+func (c *TaggedUrn) IsPattern() bool {
+	return !c.IsConcreteInstance()
+}
+
+// WithTag returns a new tagged URN with an added or updated tag
+// Key is normalized to lowercase; value is preserved as-is
+func (c *TaggedUrn) WithTag(key, value string) *TaggedUrn {
+	newTags := make(map[string]string)
+	for k, v := range c.tags {
+		newTags[k] = v
+	}
+	key = strings.ToLower(key)
+	newTags[key] = value
+	literals := setLiteral(c.literalsFor(newTags), key, isRegexValue(value))
+	return &TaggedUrn{prefix: c.prefix, tags: newTags, literals: literals}
+}
+
+// WithRegexTag returns a new tagged URN with key constrained by the regular
+// expression expr, stored as the value "/expr/"
+// Only the parser (for an unquoted value) and WithRegexTag give a "/.../"
+// value regex meaning; WithTag, NewTaggedUrnFromTags and the builder keep it
+// literal. expr must compile, must not contain ';' and must contain a
+// character not allowed in unquoted values (anchor a plain body, as in
+// ^abc), so that the URN reads back the same; otherwise ErrorInvalidRegex is
+// returned. Key is normalized to lowercase.
+func (c *TaggedUrn) WithRegexTag(key, expr string) (*TaggedUrn, error) {
+	value := "/" + expr + "/"
+	if !isRegexValue(value) || strings.ContainsRune(expr, ';') {
+		return nil, &TaggedUrnError{
+			Code:    ErrorInvalidRegex,
+			Message: fmt.Sprintf("regex value '%s' would read back as a literal", value),
+		}
+	}
+	if _, err := compileRegexValue(value); err != nil {
+		return nil, err
+	}
+	urn := c.WithTag(key, value)
+	delete(urn.literals, strings.ToLower(key))
+	return urn, nil
+}
+
+// withTagFrom is WithTag with src's value for key, keeping its literal mark
+func (c *TaggedUrn) withTagFrom(src *TaggedUrn, key string) *TaggedUrn {
+	urn := c.WithTag(key, src.tags[key])
+	urn.literals = setLiteral(urn.literals, key, src.literals[key])
+	return urn
+}
+
+// WithoutTag returns a new tagged URN with a tag removed
+// Key is normalized to lowercase for case-insensitive removal
+func (c *TaggedUrn) WithoutTag(key string) *TaggedUrn {
+	newTags := make(map[string]string)
 	key = strings.ToLower(key)
 	for k, v := range c.tags {
 		if k != key {
 			newTags[k] = v
 		}
 	}
-	return &TaggedUrn{prefix: c.prefix, tags: newTags}
+	return &TaggedUrn{prefix: c.prefix, tags: newTags, literals: c.literalsFor(newTags)}
+}
+
+// MapValues returns a new tagged URN with every value replaced by fn(key, value)
+// Keys are unchanged. Returning a special value (*, ! or ?) turns the tag
+// into that constraint; returning "" removes the tag, since empty values are
+// not allowed. The original URN is unchanged.
+func (c *TaggedUrn) MapValues(fn func(key, value string) string) *TaggedUrn {
+	newTags := make(map[string]string, len(c.tags))
+	literals := make(map[string]bool)
+	for k, v := range c.tags {
+		if mapped := fn(k, v); mapped != "" {
+			newTags[k] = mapped
+			literals[k] = c.literals[k] && mapped == v || mapped != v && isRegexValue(mapped)
+		}
+	}
+	return &TaggedUrn{prefix: c.prefix, tags: newTags, literals: literalKeys(literals)}
+}
+
+// ComplementTag returns a new tagged URN whose constraint on key is the
+// complement of this URN's, for generating negative test patterns
+// The flat model cannot express every complement:
+//
+//	K=*            -> K=!  (exact)
+//	K=!            -> K=*  (exact)
+//	K=v, K=@enum,  -> K=!  (best effort: the true complement, "absent or
+//	K=/re/                  any other value", cannot be written as one tag;
+//	                        K=! covers only its "absent" half, so it still
+//	                        never matches an instance the original accepts)
+//	K=~v           -> ErrorNoComplement ("present and not v" has no form)
+//	K=? or missing -> ErrorNoComplement (nothing is excluded, so the
+//	                  complement would match no instance)
+//
+// Other tags are unchanged. Key is normalized to lowercase.
+func (c *TaggedUrn) ComplementTag(key string) (*TaggedUrn, error) {
+	key = strings.ToLower(key)
+	value, exists := c.tags[key]
+	var complement string
+	switch kind := c.kindOf(key); {
+	case !exists || kind == KindUnspecified:
+		return nil, &TaggedUrnError{
+			Code:    ErrorNoComplement,
+			Message: fmt.Sprintf("tag '%s' is unconstrained, so its complement matches nothing", key),
+		}
+	case kind == KindConditional:
+		return nil, &TaggedUrnError{
+			Code:    ErrorNoComplement,
+			Message: fmt.Sprintf("complement of conditional tag '%s=%s' cannot be expressed", key, value),
+		}
+	case kind == KindMustNotHave:
+		complement = "*"
+	default:
+		complement = "!"
+	}
+	return c.WithTag(key, complement), nil
+}
+
+// Tighten returns a new tagged URN with a tag specialized to a concrete value
+// Only monotonic specialization is allowed: the current value must be
+// missing, * (must-have-any) or ? (unspecified). Tightening to the value
+// already present is a no-op. Any other existing value (a different concrete
+// value or !) would contradict the URN and returns ErrorIncompatibleTighten.
+// Key is normalized to lowercase; value is preserved as-is.
+func (c *TaggedUrn) Tighten(key, value string) (*TaggedUrn, error) {
+	key = strings.ToLower(key)
+	if value == "" {
+		return nil, &TaggedUrnError{
+			Code:    ErrorEmptyTag,
+			Message: fmt.Sprintf("empty value for key '%s'", key),
+		}
+	}
+	if value == "*" || value == "?" || value == "!" {
+		return nil, &TaggedUrnError{
+			Code:    ErrorIncompatibleTighten,
+			Message: fmt.Sprintf("cannot tighten '%s' to special value '%s': a concrete value is required", key, value),
+		}
+	}
+
+	current, exists := c.tags[key]
+	if !exists || current == "*" || current == "?" {
+		return c.WithTag(key, value), nil
+	}
+	if current == value {
+		return c, nil
+	}
+	return nil, &TaggedUrnError{
+		Code:    ErrorIncompatibleTighten,
+		Message: fmt.Sprintf("cannot tighten '%s' to '%s': existing value '%s' is not a wildcard", key, value, current),
+	}
 }
 
 // Matches checks if this URN (instance) matches a pattern based on tag compatibility
@@ -456,7 +1437,7 @@ func (c *TaggedUrn) ConformsTo(pattern *TaggedUrn) (bool, error) {
 			Message: "cannot match against nil pattern",
 		}
 	}
-	return checkMatch(c.tags, c.prefix, pattern.tags, pattern.prefix)
+	return checkMatch(c, pattern, MatchOptions{})
 }
 
 // Accepts checks if this URN (pattern) accepts the given instance.
@@ -468,17 +1449,281 @@ func (c *TaggedUrn) Accepts(instance *TaggedUrn) (bool, error) {
 			Message: "cannot match against nil instance",
 		}
 	}
-	return checkMatch(instance.tags, instance.prefix, c.tags, c.prefix)
+	return checkMatch(instance, c, MatchOptions{})
+}
+
+// QuorumConstraint requires at least Min of Keys to be present in an
+// instance, with any value
+// A key counts as present unless it is missing or holds '!' or '?'.
+type QuorumConstraint struct {
+	Keys []string
+	Min  int
+}
+
+// SatisfiedBy reports whether instance has at least Min of the keys present
+// Keys are compared case-insensitively and duplicates count once.
+func (q QuorumConstraint) SatisfiedBy(instance *TaggedUrn) bool {
+	return countPresentKeys(instance, q.Keys) >= q.Min
+}
+
+// countPresentKeys counts the distinct keys present in instance, using the
+// QuorumConstraint rules: case-insensitive, '!' and '?' not counted
+func countPresentKeys(instance *TaggedUrn, keys []string) int {
+	seen := make(map[string]bool, len(keys))
+	present := 0
+	for _, key := range keys {
+		key = strings.ToLower(key)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if value, exists := instance.tags[key]; exists && value != "!" && value != "?" {
+			present++
+		}
+	}
+	return present
+}
+
+// MatchesQuorum checks that this URN (pattern) accepts instance and that at
+// least min of keys are present in instance
+// The quorum is evaluated on top of the per-tag truth table, expressing
+// policies such as "at least 2 of these flags" that a flat pattern cannot.
+func (c *TaggedUrn) MatchesQuorum(instance *TaggedUrn, keys []string, min int) (bool, error) {
+	if min < 0 {
+		return false, &TaggedUrnError{
+			Code:    ErrorInvalidFormat,
+			Message: fmt.Sprintf("quorum minimum must not be negative, got %d", min),
+		}
+	}
+	accepts, err := c.Accepts(instance)
+	if err != nil || !accepts {
+		return false, err
+	}
+	return QuorumConstraint{Keys: keys, Min: min}.SatisfiedBy(instance), nil
+}
+
+// MatchesExactlyOne checks that this URN (pattern) accepts instance and that
+// exactly one of keys is present in instance, with any value
+// Presence follows QuorumConstraint: keys are case-insensitive, duplicates
+// count once, and a key holding '!' or '?' is not present. This expresses
+// mutually exclusive groups such as "exactly one of pdf, docx, txt".
+func (c *TaggedUrn) MatchesExactlyOne(instance *TaggedUrn, keys []string) (bool, error) {
+	accepts, err := c.Accepts(instance)
+	if err != nil || !accepts {
+		return false, err
+	}
+	return countPresentKeys(instance, keys) == 1, nil
+}
+
+// AcceptsTag checks whether an instance holding key=value would satisfy this
+// URN's (as pattern) constraint on key, applying the same per-tag rules as
+// Accepts
+// Unlike HasTag, which compares the stored value, AcceptsTag("ext", "pdf")
+// is true for ext=*, ext=pdf, ext=?, ext=~pdf or a missing ext. The key is
+// case-insensitive. A constraint that cannot be evaluated (such as an
+// unregistered @enum) does not accept. (Accepts itself takes a whole
+// instance URN, hence the name.)
+func (c *TaggedUrn) AcceptsTag(key, value string) bool {
+	key = strings.ToLower(key)
+	var patt *string
+	if v, exists := c.tags[key]; exists {
+		patt = &v
+	}
+	ok, err := valuesMatch(key, &value, patt, false, c.literals[key], MatchOptions{})
+	return err == nil && ok
+}
+
+// MatchOptions adjusts how concrete values are compared during matching
+// The zero value gives the default semantics used by ConformsTo and Accepts.
+type MatchOptions struct {
+	// CaseInsensitiveValues compares concrete values with strings.EqualFold
+	// Storage is unaffected; only the comparison during matching changes.
+	CaseInsensitiveValues bool
+
+	// MissingInstanceTagIsWildcard restores the legacy semantics where a tag
+	// absent from the instance satisfies any pattern constraint, as if the
+	// instance held K=?. By default a missing tag fails K=v and K=*.
+	MissingInstanceTagIsWildcard bool
+
+	// Enums resolves "@name" pattern values; nil uses the package-wide
+	// registry populated by RegisterEnum
+	Enums *EnumRegistry
+
+	// HierarchicalKeys lists keys whose concrete values are '/'-separated paths
+	// For these keys a pattern value matches any instance value at or below it
+	// (pattern path=/a/b matches /a/b, /a/b/c and /a/b/c/d, but not /a/bc).
+	// A single trailing slash is ignored and the root "/" covers every
+	// absolute path. Segments compare case-sensitively unless
+	// CaseInsensitiveValues is also set. Other keys keep exact matching.
+	HierarchicalKeys map[string]bool
+}
+
+// MatchesWithOptions checks if this URN (instance) satisfies the pattern's constraints
+// using the given options. With zero-valued options it is identical to ConformsTo.
+func (c *TaggedUrn) MatchesWithOptions(pattern *TaggedUrn, opts MatchOptions) (bool, error) {
+	if pattern == nil {
+		return false, &TaggedUrnError{
+			Code:    ErrorInvalidFormat,
+			Message: "cannot match against nil pattern",
+		}
+	}
+	return checkMatch(c, pattern, opts)
+}
+
+// MatchesIgnoring checks if this URN (instance) satisfies the pattern's
+// constraints after dropping ignoreKeys from both sides, e.g. to route
+// without regard to region
+// Keys are case-insensitive. Otherwise identical to ConformsTo.
+func (c *TaggedUrn) MatchesIgnoring(pattern *TaggedUrn, ignoreKeys []string) (bool, error) {
+	if pattern == nil {
+		return false, &TaggedUrnError{
+			Code:    ErrorInvalidFormat,
+			Message: "cannot match against nil pattern",
+		}
+	}
+	ignored := make(map[string]bool, len(ignoreKeys))
+	for _, key := range ignoreKeys {
+		ignored[strings.ToLower(key)] = true
+	}
+	without := func(urn *TaggedUrn) *TaggedUrn {
+		kept := make(map[string]string, len(urn.tags))
+		for key, value := range urn.tags {
+			if !ignored[key] {
+				kept[key] = value
+			}
+		}
+		return &TaggedUrn{prefix: urn.prefix, tags: kept, literals: urn.literalsFor(kept)}
+	}
+	return checkMatch(without(c), without(pattern), MatchOptions{})
+}
+
+// MatchesStrict checks if this URN (instance) satisfies the pattern's constraints,
+// first verifying that the receiver really is an instance.
+// A real instance never forbids (!), leaves unspecified (?) or regex-matches
+// (/re/) its own tags, so a receiver holding any of these returns
+// ErrorPatternUsedAsInstance instead of silently applying the symmetric truth
+// table. Use ConformsTo for the permissive behavior.
+func (c *TaggedUrn) MatchesStrict(pattern *TaggedUrn) (bool, error) {
+	for _, key := range c.sortedKeys() {
+		value := c.tags[key]
+		if value == "!" || value == "?" || c.kindOf(key) == KindRegex {
+			return false, &TaggedUrnError{
+				Code:    ErrorPatternUsedAsInstance,
+				Message: fmt.Sprintf("URN '%s' is used as an instance but tag '%s' holds pattern-only value '%s'", c.ToString(), key, value),
+			}
+		}
+	}
+	return c.ConformsTo(pattern)
+}
+
+// MatchesExplain checks if this URN (instance) conforms to pattern and, when it
+// does not, describes why
+// The reason names the first offending key in canonical order, e.g.
+// "tag 'ext': pattern requires 'pdf' but instance has 'png'". It is empty
+// when the URNs match. Errors are the same as ConformsTo's.
+func (c *TaggedUrn) MatchesExplain(pattern *TaggedUrn) (bool, string, error) {
+	matches, err := c.ConformsTo(pattern)
+	if err != nil || matches {
+		return matches, "", err
+	}
+
+	keys := make(map[string]bool, len(c.tags)+len(pattern.tags))
+	for key := range c.tags {
+		keys[key] = true
+	}
+	for key := range pattern.tags {
+		keys[key] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	for _, key := range sorted {
+		var instVal, pattVal *string
+		if inst, exists := c.tags[key]; exists {
+			instVal = &inst
+		}
+		if patt, exists := pattern.tags[key]; exists {
+			pattVal = &patt
+		}
+		if ok, _ := valuesMatch(key, instVal, pattVal, c.literals[key], pattern.literals[key], MatchOptions{}); !ok {
+			return false, mismatchReason(key, instVal, pattVal, pattern.literals[key]), nil
+		}
+	}
+	return false, "", nil
+}
+
+// mismatchReason describes why valuesMatch rejected an instance value
+func mismatchReason(key string, inst, patt *string, pattLiteral bool) string {
+	switch {
+	case *patt == "!":
+		return fmt.Sprintf("tag '%s': pattern forbids it but instance has '%s'", key, *inst)
+	case inst != nil && *inst == "!" && *patt == "*":
+		return fmt.Sprintf("tag '%s': instance forbids it but pattern requires it", key)
+	case inst != nil && *inst == "!":
+		return fmt.Sprintf("tag '%s': instance forbids it but pattern requires '%s'", key, *patt)
+	case !pattLiteral && isConditional(*patt):
+		return fmt.Sprintf("tag '%s': pattern allows only '%s' when present but instance has '%s'", key, (*patt)[1:], *inst)
+	case inst == nil && *patt == "*":
+		return fmt.Sprintf("tag '%s': pattern requires it but instance lacks it", key)
+	case inst == nil:
+		return fmt.Sprintf("tag '%s': pattern requires '%s' but instance lacks it", key, *patt)
+	default:
+		return fmt.Sprintf("tag '%s': pattern requires '%s' but instance has '%s'", key, *patt, *inst)
+	}
+}
+
+// ValidateInstance checks that this URN makes sense as a concrete instance
+// For now it rejects "?" values, which are meaningless on an instance, with
+// ErrorPatternUsedAsInstance. It is a cheap guard before storing a URN
+// assembled through WithTag, Merge and the like.
+func (c *TaggedUrn) ValidateInstance() error {
+	for _, key := range c.sortedKeys() {
+		if c.tags[key] == "?" {
+			return &TaggedUrnError{
+				Code:    ErrorPatternUsedAsInstance,
+				Message: fmt.Sprintf("URN '%s' is used as an instance but tag '%s' is unspecified (?)", c.ToString(), key),
+			}
+		}
+	}
+	return nil
+}
+
+// ValidatePattern checks that this URN's constraints can be evaluated
+// Regex values must compile (ErrorInvalidRegex) and enum references must be
+// registered with RegisterEnum (ErrorUnknownEnum). Parsing compiles nothing,
+// leaving regexes to the first match, so call this to fail early instead.
+func (c *TaggedUrn) ValidatePattern() error {
+	for _, key := range c.sortedKeys() {
+		value := c.tags[key]
+		switch c.kindOf(key) {
+		case KindRegex:
+			if _, err := compileRegexValue(value); err != nil {
+				return err
+			}
+		case KindEnum:
+			if _, exists := defaultEnums.Lookup(value[1:]); !exists {
+				return &TaggedUrnError{
+					Code:    ErrorUnknownEnum,
+					Message: fmt.Sprintf("tag '%s' references unknown enum '%s'", key, value[1:]),
+				}
+			}
+		}
+	}
+	return nil
 }
 
 // checkMatch is the core matching: does instance satisfy pattern's constraints?
-func checkMatch(instanceTags map[string]string, instancePrefix string, patternTags map[string]string, patternPrefix string) (bool, error) {
-	if instancePrefix != patternPrefix {
+func checkMatch(instance, pattern *TaggedUrn, opts MatchOptions) (bool, error) {
+	if instance.prefix != pattern.prefix {
 		return false, &TaggedUrnError{
 			Code:    ErrorPrefixMismatch,
-			Message: fmt.Sprintf("cannot compare URNs with different prefixes: '%s' vs '%s'", instancePrefix, patternPrefix),
+			Message: fmt.Sprintf("cannot compare URNs with different prefixes: '%s' vs '%s'", instance.prefix, pattern.prefix),
 		}
 	}
+	instanceTags, patternTags := instance.tags, pattern.tags
 
 	allKeys := make(map[string]bool)
 	for key := range instanceTags {
@@ -500,7 +1745,11 @@ func checkMatch(instanceTags map[string]string, instancePrefix string, patternTa
 			pattVal = &patt
 		}
 
-		if !valuesMatch(instVal, pattVal) {
+		matches, err := valuesMatch(key, instVal, pattVal, instance.literals[key], pattern.literals[key], opts)
+		if err != nil {
+			return false, err
+		}
+		if !matches {
 			return false, nil
 		}
 	}
@@ -534,49 +1783,152 @@ func checkMatch(instanceTags map[string]string, instancePrefix string, patternTa
 // | K=v      | K=*     | OK     | Pattern wants any, v satisfies |
 // | K=v      | K=v     | OK     | Exact match |
 // | K=v      | K=w     | NO     | Value mismatch (v≠w) |
-func valuesMatch(inst, patt *string) bool {
+//
+// A pattern value "@name" behaves like K=v where v may be any value of the
+// named enum; an unregistered name is an ErrorUnknownEnum error.
+//
+// A pattern value "/re/" matches instance values accepted by the regex.
+// Regex values in the instance stay literal: they only match an identical
+// pattern value, so two different regexes never match each other (and
+// IsCompatibleWith does not call them compatible).
+//
+// A pattern value "~v" is conditional: an absent (or !) instance tag
+// matches, a present one must satisfy v as if the pattern were K=v.
+//
+// instLiteral and pattLiteral mark quoted values that only look like @enum,
+// ~conditional or /regex/ syntax; they compare as plain values.
+func valuesMatch(key string, inst, patt *string, instLiteral, pattLiteral bool, opts MatchOptions) (bool, error) {
 	// Pattern has no constraint (no entry or explicit ?)
 	if patt == nil || *patt == "?" {
-		return true
+		return true, nil
 	}
 
-	// Instance doesn't care (explicit ?)
+	// Instance doesn't care (explicit ?, or missing under the legacy option)
 	if inst != nil && *inst == "?" {
-		return true
+		return true, nil
+	}
+	if inst == nil && opts.MissingInstanceTagIsWildcard {
+		return true, nil
 	}
 
 	// Pattern: must-not-have (!)
 	if *patt == "!" {
 		if inst == nil {
-			return true // Instance absent, pattern wants absent
+			return true, nil // Instance absent, pattern wants absent
 		}
 		if *inst == "!" {
-			return true // Both say absent
+			return true, nil // Both say absent
+		}
+		return false, nil // Instance has value, pattern wants absent
+	}
+
+	// Pattern: conditional (~v), absent is fine, present must satisfy v
+	if !pattLiteral && isConditional(*patt) {
+		if inst == nil || *inst == "!" {
+			return true, nil
 		}
-		return false // Instance has value, pattern wants absent
+		cond := (*patt)[1:]
+		return valuesMatch(key, inst, &cond, instLiteral, false, opts)
 	}
 
 	// Instance: must-not-have conflicts with pattern wanting value
 	if inst != nil && *inst == "!" {
-		return false // Conflict: absent vs value or present
+		return false, nil // Conflict: absent vs value or present
 	}
 
 	// Pattern: must-have-any (*)
 	if *patt == "*" {
 		if inst == nil {
-			return false // Instance missing, pattern wants present
+			return false, nil // Instance missing, pattern wants present
 		}
-		return true // Instance has value, pattern wants any
+		return true, nil // Instance has value, pattern wants any
 	}
 
 	// Pattern: exact value
 	if inst == nil {
-		return false // Instance missing, pattern wants exact value
+		return false, nil // Instance missing, pattern wants exact value
 	}
 	if *inst == "*" {
-		return true // Instance accepts any, pattern's value is fine
+		return true, nil // Instance accepts any, pattern's value is fine
 	}
-	return *inst == *patt // Both have values, must match exactly
+	if !instLiteral && isRegexValue(*inst) {
+		return !pattLiteral && *inst == *patt, nil // Instance regexes are literal
+	}
+	if !pattLiteral && isEnumReference(*patt) {
+		if !instLiteral && *inst == *patt {
+			return true, nil // Same reference, no lookup needed
+		}
+		return enumMatches(*inst, *patt, opts)
+	}
+	if !pattLiteral && isRegexValue(*patt) {
+		return regexMatches(*inst, *patt)
+	}
+	if opts.HierarchicalKeys[key] {
+		return pathHasPrefix(*inst, *patt, opts.CaseInsensitiveValues), nil
+	}
+	if opts.CaseInsensitiveValues {
+		return strings.EqualFold(*inst, *patt), nil
+	}
+	return *inst == *patt, nil // Both have values, must match exactly
+}
+
+// isConditional checks if a tag value is a "~v" conditional constraint
+func isConditional(value string) bool {
+	return len(value) > 1 && value[0] == '~'
+}
+
+// enumMatches checks an instance value against an "@name" pattern value
+func enumMatches(inst, ref string, opts MatchOptions) (bool, error) {
+	registry := opts.Enums
+	if registry == nil {
+		registry = defaultEnums
+	}
+	values, exists := registry.Lookup(ref[1:])
+	if !exists {
+		return false, &TaggedUrnError{
+			Code:    ErrorUnknownEnum,
+			Message: fmt.Sprintf("unknown enum '%s'", ref[1:]),
+		}
+	}
+	for _, v := range values {
+		if v == inst || (opts.CaseInsensitiveValues && strings.EqualFold(v, inst)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// pathHasPrefix checks segment-wise whether path lies at or under prefix
+// Both are split on '/'; a single trailing slash is ignored on either side,
+// so "/a/b/" and "/a/b" are the same path. "/a/b" covers "/a/b" and "/a/b/c"
+// but not "/a/bc".
+func pathHasPrefix(path, prefix string, caseInsensitive bool) bool {
+	pathSegments := splitPath(path)
+	prefixSegments := splitPath(prefix)
+	if len(prefixSegments) > len(pathSegments) {
+		return false
+	}
+	for i, segment := range prefixSegments {
+		if caseInsensitive {
+			if !strings.EqualFold(segment, pathSegments[i]) {
+				return false
+			}
+		} else if segment != pathSegments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// splitPath splits a path into segments, ignoring one trailing slash
+func splitPath(path string) []string {
+	if len(path) > 1 {
+		path = strings.TrimSuffix(path, "/")
+	}
+	if path == "/" {
+		return []string{""}
+	}
+	return strings.Split(path, "/")
 }
 
 // ConformsToStr checks if this URN (instance) satisfies a string pattern's constraints.
@@ -600,62 +1952,346 @@ func (c *TaggedUrn) AcceptsStr(instanceStr string) (bool, error) {
 // Specificity returns the specificity score for URN matching
 // More specific URNs have higher scores and are preferred
 // Graded scoring:
-// - K=v (exact value): 3 points (most specific)
-// - K=* (must-have-any): 2 points
+// - K=v (exact value) or K=/re/ (regex): 3 points (most specific)
+// - K=* (must-have-any), K=@name (enum) or K=~v (conditional): 2 points
 // - K=! (must-not-have): 1 point
 // - K=? (unspecified): 0 points (least specific)
 func (c *TaggedUrn) Specificity() int {
 	score := 0
-	for _, value := range c.tags {
-		switch value {
-		case "?":
-			score += 0
-		case "!":
-			score += 1
-		case "*":
-			score += 2
-		default:
-			score += 3 // exact value
-		}
+	for key := range c.tags {
+		score += c.tagScore(key)
 	}
 	return score
 }
 
-// SpecificityTuple returns specificity as a tuple for tie-breaking
-// Returns (exact_count, must_have_any_count, must_not_count)
-// Compare tuples lexicographically when sum scores are equal
-func (c *TaggedUrn) SpecificityTuple() (int, int, int) {
-	exact := 0
-	mustHaveAny := 0
-	mustNot := 0
-	for _, value := range c.tags {
-		switch value {
-		case "?":
-			// 0 points, not counted
-		case "!":
-			mustNot++
-		case "*":
-			mustHaveAny++
-		default:
-			exact++
-		}
+// SpecificityInt64 returns Specificity accumulated in an int64
+// Each tag scores at most 3, so even on 32-bit platforms Specificity only
+// overflows past roughly 700 million tags; this variant removes that bound
+// for callers storing scores of machine-generated URNs.
+func (c *TaggedUrn) SpecificityInt64() int64 {
+	var score int64
+	for key := range c.tags {
+		score += int64(c.tagScore(key))
 	}
-	return exact, mustHaveAny, mustNot
+	return score
 }
 
-// IsMoreSpecificThan checks if this URN is more specific than another
-func (c *TaggedUrn) IsMoreSpecificThan(other *TaggedUrn) (bool, error) {
-	if other == nil {
-		return false, &TaggedUrnError{
-			Code:    ErrorInvalidFormat,
-			Message: "cannot compare against nil URN",
-		}
+// TagKind classifies a tag value by the kind of constraint it expresses
+type TagKind int
+
+const (
+	// KindUnspecified is K=? (or a missing tag): no constraint
+	KindUnspecified TagKind = iota
+	// KindMustNotHave is K=!: the tag must be absent
+	KindMustNotHave
+	// KindMustHaveAny is K=*: the tag must be present with any value
+	KindMustHaveAny
+	// KindExact is K=v: the tag must be present with exactly value v
+	KindExact
+	// KindEnum is K=@name: the tag must hold one of the named enum's values
+	KindEnum
+	// KindConditional is K=~v: the tag may be absent, but if present must be v
+	KindConditional
+	// KindRegex is K=/re/: the tag must be present with a value matching re
+	KindRegex
+)
+
+// String returns a readable name for the kind
+func (k TagKind) String() string {
+	switch k {
+	case KindUnspecified:
+		return "unspecified"
+	case KindMustNotHave:
+		return "must-not-have"
+	case KindMustHaveAny:
+		return "must-have-any"
+	case KindExact:
+		return "exact"
+	case KindEnum:
+		return "enum"
+	case KindConditional:
+		return "conditional"
+	case KindRegex:
+		return "regex"
+	default:
+		return fmt.Sprintf("TagKind(%d)", int(k))
 	}
+}
 
-	// First check prefix
-	if c.prefix != other.prefix {
-		return false, &TaggedUrnError{
-			Code:    ErrorPrefixMismatch,
+// KindOf classifies a raw tag value as if it were written unquoted
+// A quoted literal such as cap:x="@rgb" is exact within its URN even though
+// KindOf("@rgb") is KindEnum; Constraints reports kinds as the URN sees them.
+func KindOf(value string) TagKind {
+	switch value {
+	case "?":
+		return KindUnspecified
+	case "!":
+		return KindMustNotHave
+	case "*":
+		return KindMustHaveAny
+	}
+	if isEnumReference(value) {
+		return KindEnum
+	}
+	if isConditional(value) {
+		return KindConditional
+	}
+	if isRegexValue(value) {
+		return KindRegex
+	}
+	return KindExact
+}
+
+// hasPatternSyntax checks if a value would read as pattern syntax rather
+// than a plain value when written unquoted
+// A quoted value of this form is recorded as a literal by the parser.
+func hasPatternSyntax(value string) bool {
+	return isEnumReference(value) || isConditional(value) || isRegexValue(value)
+}
+
+// kindOf classifies the value stored under key; a quoted literal is exact
+func (c *TaggedUrn) kindOf(key string) TagKind {
+	if c.literals[key] {
+		return KindExact
+	}
+	return KindOf(c.tags[key])
+}
+
+// literalsFor returns the literal marks of c that still apply to tags
+// A mark carries over only while the key keeps the value it has in c.
+func (c *TaggedUrn) literalsFor(tags map[string]string) map[string]bool {
+	var literals map[string]bool
+	for key := range c.literals {
+		if value, exists := tags[key]; exists && value == c.tags[key] {
+			if literals == nil {
+				literals = make(map[string]bool)
+			}
+			literals[key] = true
+		}
+	}
+	return literals
+}
+
+// codeLiterals marks the "/.../" values among tags set in code as literals
+// Only the parser and WithRegexTag create regexes, so such a value from
+// NewTaggedUrnFromTags, the builder or a struct matches as an exact string.
+func codeLiterals(tags map[string]string) map[string]bool {
+	var literals map[string]bool
+	for key, value := range tags {
+		literals = setLiteral(literals, key, isRegexValue(value))
+	}
+	return literals
+}
+
+// literalKeys drops the false entries of marks, returning nil when none is set
+func literalKeys(marks map[string]bool) map[string]bool {
+	var literals map[string]bool
+	for key, literal := range marks {
+		literals = setLiteral(literals, key, literal)
+	}
+	return literals
+}
+
+// setLiteral records whether key holds a literal, allocating the map on demand
+func setLiteral(literals map[string]bool, key string, literal bool) map[string]bool {
+	if !literal {
+		delete(literals, key)
+		return literals
+	}
+	if literals == nil {
+		literals = make(map[string]bool)
+	}
+	literals[key] = true
+	return literals
+}
+
+// Constraint is a single tag constraint: a key with its raw value and kind
+type Constraint struct {
+	Key   string
+	Kind  TagKind
+	Value string
+}
+
+// String renders the constraint in URN tag syntax (e.g. "ext=pdf", "ext", "ext=!")
+func (c Constraint) String() string {
+	if c.Kind == KindMustHaveAny {
+		return c.Key
+	}
+	if (c.Kind == KindExact && hasPatternSyntax(c.Value)) || needsQuoting(c.Value) {
+		return fmt.Sprintf("%s=%s", c.Key, quoteValue(c.Value))
+	}
+	return fmt.Sprintf("%s=%s", c.Key, c.Value)
+}
+
+// KeyConstraint is a tag constraint with its sentinel syntax decoded
+// Unlike Constraint, Value never carries markers: it is the value for
+// KindExact, the enum name (without '@') for KindEnum, the value (without
+// '~') for KindConditional, the expression (without slashes) for KindRegex,
+// and empty for KindMustHaveAny, KindMustNotHave and KindUnspecified.
+type KeyConstraint struct {
+	Key   string
+	Kind  TagKind
+	Value string
+}
+
+// Constraints returns every tag as a KeyConstraint, sorted by key
+// Renderers can switch on Kind without decoding '*', '!', '?' or markers.
+func (c *TaggedUrn) Constraints() []KeyConstraint {
+	constraints := make([]KeyConstraint, 0, len(c.tags))
+	for _, key := range c.sortedKeys() {
+		value := c.tags[key]
+		kind := c.kindOf(key)
+		switch kind {
+		case KindMustHaveAny, KindMustNotHave, KindUnspecified:
+			value = ""
+		case KindEnum, KindConditional:
+			value = value[1:]
+		case KindRegex:
+			value = value[1 : len(value)-1]
+		}
+		constraints = append(constraints, KeyConstraint{Key: key, Kind: kind, Value: value})
+	}
+	return constraints
+}
+
+// KindScores assigns a specificity score to each tier of tag kind
+// @enum and ~conditional values score as MustHaveAny; /regex/ values
+// score as Exact.
+type KindScores struct {
+	Exact       int
+	MustHaveAny int
+	MustNot     int
+	Unspecified int
+}
+
+// DefaultKindScores are the scores used by Specificity
+var DefaultKindScores = KindScores{Exact: 3, MustHaveAny: 2, MustNot: 1, Unspecified: 0}
+
+// score returns the score of a single tag kind
+func (s KindScores) score(kind TagKind) int {
+	switch kind {
+	case KindUnspecified:
+		return s.Unspecified
+	case KindMustNotHave:
+		return s.MustNot
+	case KindMustHaveAny, KindEnum, KindConditional:
+		return s.MustHaveAny
+	default:
+		return s.Exact // exact value or regex
+	}
+}
+
+// tagScore returns the graded specificity score of the tag under key
+func (c *TaggedUrn) tagScore(key string) int {
+	return DefaultKindScores.score(c.kindOf(key))
+}
+
+// SpecificityWithScores returns the specificity score using custom per-kind
+// scores, e.g. to rank must-not-have above must-have-any
+// With DefaultKindScores it equals Specificity().
+func (c *TaggedUrn) SpecificityWithScores(scores KindScores) int {
+	score := 0
+	for key := range c.tags {
+		score += scores.score(c.kindOf(key))
+	}
+	return score
+}
+
+// GeneralityOver counts the keys of a fixed universe this pattern leaves
+// unconstrained (missing or ?)
+// Broader patterns score higher. Unlike a negated Specificity it accounts
+// for keys the pattern omits, which is why it needs the universe: a pattern
+// on its own cannot say which dimensions exist. Keys are case-insensitive
+// and repeats count once; tags outside the universe are ignored.
+func (c *TaggedUrn) GeneralityOver(keys []string) int {
+	seen := make(map[string]bool, len(keys))
+	open := 0
+	for _, key := range keys {
+		key = strings.ToLower(key)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if value, exists := c.tags[key]; !exists || value == "?" {
+			open++
+		}
+	}
+	return open
+}
+
+// WeightedSpecificity returns the specificity score with per-key weights
+// Each tag's graded score (3/2/1/0) is multiplied by weights[key];
+// keys absent from the map use weight 1, so a nil map equals Specificity().
+// Weight keys are case-insensitive like tag keys; if two differ only in
+// case, the all-lowercase one wins.
+func (c *TaggedUrn) WeightedSpecificity(weights map[string]int) int {
+	return c.weightedSpecificity(lowercaseKeys(weights))
+}
+
+// lowercaseKeys returns m with its keys lowercased, preferring an
+// all-lowercase key over others that differ from it only in case
+func lowercaseKeys[V any](m map[string]V) map[string]V {
+	normalized := make(map[string]V, len(m))
+	for key, value := range m {
+		lower := strings.ToLower(key)
+		if _, exists := m[lower]; exists && key != lower {
+			continue
+		}
+		normalized[lower] = value
+	}
+	return normalized
+}
+
+// weightedSpecificity is WeightedSpecificity for weights with lowercase keys
+func (c *TaggedUrn) weightedSpecificity(weights map[string]int) int {
+	score := 0
+	for key := range c.tags {
+		weight, exists := weights[key]
+		if !exists {
+			weight = 1
+		}
+		score += c.tagScore(key) * weight
+	}
+	return score
+}
+
+// SpecificityTuple returns specificity as a tuple for tie-breaking
+// Returns (exact_count, must_have_any_count, must_not_count)
+// Compare tuples lexicographically when sum scores are equal. Kinds are
+// grouped as Specificity scores them: @enum and ~v count as must-have-any,
+// /regex/ and quoted literals as exact.
+func (c *TaggedUrn) SpecificityTuple() (int, int, int) {
+	exact := 0
+	mustHaveAny := 0
+	mustNot := 0
+	for key := range c.tags {
+		switch c.kindOf(key) {
+		case KindUnspecified:
+			// 0 points, not counted
+		case KindMustNotHave:
+			mustNot++
+		case KindMustHaveAny, KindEnum, KindConditional:
+			mustHaveAny++
+		default:
+			exact++
+		}
+	}
+	return exact, mustHaveAny, mustNot
+}
+
+// IsMoreSpecificThan checks if this URN is more specific than another
+func (c *TaggedUrn) IsMoreSpecificThan(other *TaggedUrn) (bool, error) {
+	if other == nil {
+		return false, &TaggedUrnError{
+			Code:    ErrorInvalidFormat,
+			Message: "cannot compare against nil URN",
+		}
+	}
+
+	// First check prefix
+	if c.prefix != other.prefix {
+		return false, &TaggedUrnError{
+			Code:    ErrorPrefixMismatch,
 			Message: fmt.Sprintf("cannot compare URNs with different prefixes: '%s' vs '%s'", c.prefix, other.prefix),
 		}
 	}
@@ -663,6 +2299,111 @@ func (c *TaggedUrn) IsMoreSpecificThan(other *TaggedUrn) (bool, error) {
 	return c.Specificity() > other.Specificity(), nil
 }
 
+// Distinguish returns a small pattern that this URN conforms to but none of
+// others does
+// Tags are picked greedily from this URN: each step adds the tag that rules
+// out the most still-matching others (ties go to the alphabetically first
+// key), until no other URN conforms. Others with a different prefix or nil
+// never match and are ignored. Greedy selection is not guaranteed to be
+// minimal, but is usually close. Returns ErrorNotDistinguishable when others
+// holds a URN that no subset of these tags can exclude, such as an equal one.
+func (c *TaggedUrn) Distinguish(others []*TaggedUrn) (*TaggedUrn, error) {
+	var remaining []*TaggedUrn
+	for _, other := range others {
+		if other != nil && other.prefix == c.prefix {
+			remaining = append(remaining, other)
+		}
+	}
+
+	pattern := Empty(c.prefix)
+	unused := c.sortedKeys()
+	for {
+		// Keep only the others the current pattern still matches
+		matching := remaining[:0]
+		for _, other := range remaining {
+			matches, err := other.ConformsTo(pattern)
+			if err != nil {
+				return nil, err
+			}
+			if matches {
+				matching = append(matching, other)
+			}
+		}
+		remaining = matching
+		if len(remaining) == 0 {
+			return pattern, nil
+		}
+
+		bestIndex, bestExcluded := -1, 0
+		for i, key := range unused {
+			candidate := pattern.withTagFrom(c, key)
+			excluded := 0
+			for _, other := range remaining {
+				matches, err := other.ConformsTo(candidate)
+				if err != nil {
+					return nil, err
+				}
+				if !matches {
+					excluded++
+				}
+			}
+			if excluded > bestExcluded {
+				bestIndex, bestExcluded = i, excluded
+			}
+		}
+		if bestIndex < 0 {
+			return nil, &TaggedUrnError{
+				Code:    ErrorNotDistinguishable,
+				Message: fmt.Sprintf("'%s' cannot be distinguished from '%s'", c.ToString(), remaining[0].ToString()),
+			}
+		}
+
+		key := unused[bestIndex]
+		pattern = pattern.withTagFrom(c, key)
+		unused = append(unused[:bestIndex], unused[bestIndex+1:]...)
+	}
+}
+
+// ConstraintsToReach returns the constraints a specific URN adds on top of a general one
+// The receiver is the general pattern; specific must be a specialization of
+// it (general.Accepts(specific)), otherwise ErrorNotSpecialization is returned.
+// A constraint is reported when specific's value for a key scores higher than
+// general's (a missing tag scores like ?), i.e. when it genuinely narrows the
+// general pattern. Results are sorted by key, e.g. to suggest refinements such
+// as "add ext=pdf, out=binary".
+func (c *TaggedUrn) ConstraintsToReach(specific *TaggedUrn) ([]Constraint, error) {
+	if specific == nil {
+		return nil, &TaggedUrnError{
+			Code:    ErrorInvalidFormat,
+			Message: "cannot compare against nil URN",
+		}
+	}
+
+	accepts, err := c.Accepts(specific)
+	if err != nil {
+		return nil, err
+	}
+	if !accepts {
+		return nil, &TaggedUrnError{
+			Code:    ErrorNotSpecialization,
+			Message: fmt.Sprintf("'%s' is not a specialization of '%s'", specific.ToString(), c.ToString()),
+		}
+	}
+
+	var constraints []Constraint
+	for _, key := range specific.sortedKeys() {
+		value := specific.tags[key]
+		generalScore := 0
+		if _, exists := c.tags[key]; exists {
+			generalScore = c.tagScore(key)
+		}
+		if specific.tagScore(key) > generalScore {
+			constraints = append(constraints, Constraint{Key: key, Kind: specific.kindOf(key), Value: value})
+		}
+	}
+	return constraints, nil
+}
+
 // IsEquivalent checks if two URNs are equivalent (identical tag sets).
 //
 // From order theory: in the specialization partial order defined by
@@ -735,6 +2476,140 @@ func (c *TaggedUrn) IsComparable(other *TaggedUrn) (bool, error) {
 	return aAcceptsB || bAcceptsA, nil
 }
 
+// IsCompatibleWith checks if some instance could conform to both patterns
+// Unlike IsComparable, neither pattern has to accept the other: cap:ext=pdf
+// and cap:op=generate are not comparable, yet cap:ext=pdf;op=generate
+// satisfies both. Keys are independent, so the patterns are compatible when
+// every key's two constraints overlap:
+//   - ! overlaps with !, ? and ~v (the tag is absent), but not with *, v,
+//     @enum or /re/, which all need the tag present
+//   - * overlaps with anything except !
+//   - v overlaps with w only when v == w, with ~w likewise, with @enum when v
+//     is one of its values and with /re/ when re matches v
+//   - two regexes are compatible only when they are the same string; whether
+//     two different ones share a value is not decided, so they are not
+//     compatible, but not mutually exclusive either
+//
+// Returns ErrorPrefixMismatch if the prefixes differ.
+func (c *TaggedUrn) IsCompatibleWith(other *TaggedUrn) (bool, error) {
+	compatible, _, err := c.compareConstraints(other)
+	return compatible, err
+}
+
+// MutuallyExclusive checks if no instance can conform to both patterns
+// It is the complement of IsCompatibleWith, so see there for the per-key
+// rules, except for two different regexes: no instance is known to match
+// both, yet one might, so they are neither compatible nor exclusive. For
+// example cap:ext=! and cap:ext are exclusive (absent vs present), as are
+// cap:ext=pdf and cap:ext=png, while cap:ext=! and cap:ext=~pdf are not,
+// since an instance without ext satisfies both.
+func (c *TaggedUrn) MutuallyExclusive(other *TaggedUrn) (bool, error) {
+	_, exclusive, err := c.compareConstraints(other)
+	return exclusive, err
+}
+
+// compareConstraints compares two patterns key by key, reporting whether
+// every key's constraints are known to overlap (compatible) and whether some
+// key's are known to be disjoint (exclusive)
+func (c *TaggedUrn) compareConstraints(other *TaggedUrn) (compatible, exclusive bool, err error) {
+	if other == nil {
+		return false, false, &TaggedUrnError{
+			Code:    ErrorInvalidFormat,
+			Message: "cannot compare against nil URN",
+		}
+	}
+
+	if c.prefix != other.prefix {
+		return false, false, &TaggedUrnError{
+			Code:    ErrorPrefixMismatch,
+			Message: fmt.Sprintf("cannot compare URNs with different prefixes: '%s' vs '%s'", c.prefix, other.prefix),
+		}
+	}
+
+	keys := make(map[string]bool, len(c.tags)+len(other.tags))
+	for key := range c.tags {
+		keys[key] = true
+	}
+	for key := range other.tags {
+		keys[key] = true
+	}
+
+	compatible = true
+	for key := range keys {
+		overlap, err := constraintsOverlap(key, c, other)
+		if err != nil {
+			return false, false, err
+		}
+		if overlap {
+			continue
+		}
+		compatible = false
+		_, inC := c.tags[key]
+		_, inOther := other.tags[key]
+		if !(inC && inOther && c.kindOf(key) == KindRegex && other.kindOf(key) == KindRegex) {
+			exclusive = true
+		}
+	}
+	return compatible, exclusive, nil
+}
+
+// constraintsOverlap checks if one instance value (or its absence) satisfies
+// the constraints c and other place on key
+// Rather than tabulating every pair of kinds it tries candidate instance
+// values through valuesMatch: absence, a fresh value equal to nothing, and
+// every concrete value either constraint names. Two different regexes thus
+// never overlap here; compareConstraints treats that pair as undecided.
+func constraintsOverlap(key string, c, other *TaggedUrn) (bool, error) {
+	type candidate struct {
+		value   *string
+		literal bool
+	}
+	fresh := "\x00"
+	candidates := []candidate{{}, {value: &fresh}}
+	var sides [2]candidate
+	for i, urn := range []*TaggedUrn{c, other} {
+		v, exists := urn.tags[key]
+		if !exists {
+			continue
+		}
+		sides[i] = candidate{value: &v, literal: urn.literals[key]}
+		switch urn.kindOf(key) {
+		case KindExact, KindRegex:
+			candidates = append(candidates, sides[i])
+		case KindConditional:
+			value := v[1:]
+			candidates = append(candidates, candidate{value: &value})
+		case KindEnum:
+			values, exists := defaultEnums.Lookup(v[1:])
+			if !exists {
+				return false, &TaggedUrnError{
+					Code:    ErrorUnknownEnum,
+					Message: fmt.Sprintf("unknown enum '%s'", v[1:]),
+				}
+			}
+			for j := range values {
+				candidates = append(candidates, candidate{value: &values[j]})
+			}
+		}
+	}
+
+	a, b := sides[0], sides[1]
+	for _, candidate := range candidates {
+		matchesA, err := valuesMatch(key, candidate.value, a.value, candidate.literal, a.literal, MatchOptions{})
+		if err != nil {
+			return false, err
+		}
+		matchesB, err := valuesMatch(key, candidate.value, b.value, candidate.literal, b.literal, MatchOptions{})
+		if err != nil {
+			return false, err
+		}
+		if matchesA && matchesB {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 // IsEquivalentStr is a string variant of IsEquivalent.
 func (c *TaggedUrn) IsEquivalentStr(otherStr string) (bool, error) {
 	other, err := NewTaggedUrnFromString(otherStr)
@@ -753,98 +2628,547 @@ func (c *TaggedUrn) IsComparableStr(otherStr string) (bool, error) {
 	return c.IsComparable(other)
 }
 
-// WithWildcardTag returns a new URN with a specific tag set to wildcard
-func (c *TaggedUrn) WithWildcardTag(key string) *TaggedUrn {
-	if _, exists := c.tags[key]; exists {
-		return c.WithTag(key, "*")
-	}
-	return c
+// WithWildcardTag returns a new URN with a specific tag set to wildcard
+func (c *TaggedUrn) WithWildcardTag(key string) *TaggedUrn {
+	if _, exists := c.tags[key]; exists {
+		return c.WithTag(key, "*")
+	}
+	return c
+}
+
+// Subset returns a new URN with only specified tags
+func (c *TaggedUrn) Subset(keys []string) *TaggedUrn {
+	newTags := make(map[string]string)
+	for _, key := range keys {
+		if value, exists := c.tags[key]; exists {
+			newTags[key] = value
+		}
+	}
+	return &TaggedUrn{prefix: c.prefix, tags: newTags, literals: c.literalsFor(newTags)}
+}
+
+// FilterByKind returns a new URN with only the tags whose value is of the given kind
+// For example FilterByKind(KindExact) keeps just the hard requirements,
+// dropping every *, ! and ? tag.
+func (c *TaggedUrn) FilterByKind(kind TagKind) *TaggedUrn {
+	newTags := make(map[string]string)
+	for k, v := range c.tags {
+		if c.kindOf(k) == kind {
+			newTags[k] = v
+		}
+	}
+	return &TaggedUrn{prefix: c.prefix, tags: newTags, literals: c.literalsFor(newTags)}
+}
+
+// Partition splits the URN by kind into three URNs sharing its prefix
+// required holds the tags that must be present (exact, *, @enum and /regex/),
+// forbidden holds the ! tags and optional the tags that may be absent (? and
+// ~conditional). Every tag lands in exactly one of the three.
+func (c *TaggedUrn) Partition() (required, forbidden, optional *TaggedUrn) {
+	required = &TaggedUrn{prefix: c.prefix, tags: make(map[string]string)}
+	forbidden = &TaggedUrn{prefix: c.prefix, tags: make(map[string]string)}
+	optional = &TaggedUrn{prefix: c.prefix, tags: make(map[string]string)}
+	for k, v := range c.tags {
+		switch c.kindOf(k) {
+		case KindMustNotHave:
+			forbidden.tags[k] = v
+		case KindUnspecified, KindConditional:
+			optional.tags[k] = v
+		default:
+			required.tags[k] = v
+		}
+	}
+	required.literals = c.literalsFor(required.tags)
+	return required, forbidden, optional
+}
+
+// ToInstance returns a new URN keeping only exact values, for use as a
+// concrete instance
+// This is lossy: *, !, ? tags and the @enum, ~conditional and /regex/
+// constraints are all dropped, so the result may match patterns the
+// original did not (a dropped ! no longer forbids anything) and no longer
+// records which tags had to be present.
+func (c *TaggedUrn) ToInstance() *TaggedUrn {
+	return c.FilterByKind(KindExact)
+}
+
+// Merge returns a new URN merged with another (other takes precedence for conflicts)
+// Both must have the same prefix
+func (c *TaggedUrn) Merge(other *TaggedUrn) (*TaggedUrn, error) {
+	if other == nil {
+		return nil, &TaggedUrnError{
+			Code:    ErrorInvalidFormat,
+			Message: "cannot merge with nil URN",
+		}
+	}
+
+	if c.prefix != other.prefix {
+		return nil, &TaggedUrnError{
+			Code:    ErrorPrefixMismatch,
+			Message: fmt.Sprintf("cannot merge URNs with different prefixes: '%s' vs '%s'", c.prefix, other.prefix),
+		}
+	}
+
+	newTags := make(map[string]string)
+	literals := c.literalsFor(c.tags)
+	for k, v := range c.tags {
+		newTags[k] = v
+	}
+	for k, v := range other.tags {
+		newTags[k] = v
+		literals = setLiteral(literals, k, other.literals[k])
+	}
+	return &TaggedUrn{prefix: c.prefix, tags: newTags, literals: literals}, nil
+}
+
+// MergeTracked merges urns left to right like repeated Merge (later URNs
+// take precedence) and also reports, for each key of the result, the index
+// of the URN that supplied its final value
+// All URNs must be non-nil and share one prefix (see SamePrefix); at least
+// one is required.
+func MergeTracked(urns ...*TaggedUrn) (*TaggedUrn, map[string]int, error) {
+	if len(urns) == 0 {
+		return nil, nil, &TaggedUrnError{
+			Code:    ErrorInvalidFormat,
+			Message: "cannot merge an empty list of URNs",
+		}
+	}
+	prefix, err := SamePrefix(urns)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tags := make(map[string]string)
+	var literals map[string]bool
+	sources := make(map[string]int)
+	for i, urn := range urns {
+		for k, v := range urn.tags {
+			tags[k] = v
+			literals = setLiteral(literals, k, urn.literals[k])
+			sources[k] = i
+		}
+	}
+	return &TaggedUrn{prefix: prefix, tags: tags, literals: literals}, sources, nil
+}
+
+// WithDefaults returns a new tagged URN with each tag of defaults added only
+// where this URN lacks the key
+// Existing tags always win (the opposite of Merge); both URNs must share a prefix.
+func (c *TaggedUrn) WithDefaults(defaults *TaggedUrn) (*TaggedUrn, error) {
+	if defaults == nil {
+		return nil, &TaggedUrnError{
+			Code:    ErrorInvalidFormat,
+			Message: "cannot apply nil defaults",
+		}
+	}
+
+	if c.prefix != defaults.prefix {
+		return nil, &TaggedUrnError{
+			Code:    ErrorPrefixMismatch,
+			Message: fmt.Sprintf("cannot apply defaults with a different prefix: '%s' vs '%s'", c.prefix, defaults.prefix),
+		}
+	}
+
+	newTags := make(map[string]string, len(c.tags)+len(defaults.tags))
+	literals := defaults.literalsFor(defaults.tags)
+	for k, v := range defaults.tags {
+		newTags[k] = v
+	}
+	for k, v := range c.tags {
+		newTags[k] = v
+		literals = setLiteral(literals, k, c.literals[k])
+	}
+	return &TaggedUrn{prefix: c.prefix, tags: newTags, literals: literals}, nil
+}
+
+// RedundantTags returns the keys whose value in this URN equals the value base
+// has for the same key, sorted alphabetically
+// Useful for stripping no-op overrides layered on top of a set of defaults.
+// Values are compared exactly, so "*" is only redundant against "*".
+func (c *TaggedUrn) RedundantTags(base *TaggedUrn) ([]string, error) {
+	if base == nil {
+		return nil, &TaggedUrnError{
+			Code:    ErrorInvalidFormat,
+			Message: "cannot compare with nil URN",
+		}
+	}
+
+	if c.prefix != base.prefix {
+		return nil, &TaggedUrnError{
+			Code:    ErrorPrefixMismatch,
+			Message: fmt.Sprintf("cannot compare URNs with different prefixes: '%s' vs '%s'", c.prefix, base.prefix),
+		}
+	}
+
+	var keys []string
+	for _, k := range c.sortedKeys() {
+		if v, exists := base.tags[k]; exists && v == c.tags[k] {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// UnsafeValueKeys returns, in sorted order, the keys whose values must be
+// quoted when serialized (whitespace, quotes, uppercase and so on)
+// Such URNs do not survive transports that cannot carry quotes.
+func (c *TaggedUrn) UnsafeValueKeys() []string {
+	var keys []string
+	for _, k := range c.sortedKeys() {
+		if c.literals[k] || needsQuoting(c.tags[k]) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// IsASCII checks that the prefix and every key and value are ASCII, e.g.
+// before handing the URN to an ASCII-only transport
+func (c *TaggedUrn) IsASCII() bool {
+	return isASCII(c.prefix) && len(c.NonASCIIKeys()) == 0
+}
+
+// NonASCIIKeys returns, in sorted order, the keys whose key or value holds a
+// non-ASCII character
+// The prefix is not a tag, so a non-ASCII prefix is reported only by IsASCII.
+func (c *TaggedUrn) NonASCIIKeys() []string {
+	var keys []string
+	for _, k := range c.sortedKeys() {
+		if !isASCII(k) || !isASCII(c.tags[k]) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// isASCII checks that s contains only ASCII bytes
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// ToString returns the canonical string representation of this tagged URN
+// Uses the stored prefix
+// Tags are sorted alphabetically for consistent representation
+// No trailing semicolon in canonical form
+// Values are quoted only when necessary (smart quoting)
+// Special value serialization:
+// - * (must-have-any): serialized as value-less tag (just the key)
+// - ? (unspecified): serialized as key=?
+// - ! (must-not-have): serialized as key=!
+func (c *TaggedUrn) ToString() string {
+	return string(c.AppendTo(make([]byte, 0, c.canonicalSizeHint())))
+}
+
+// AppendTo appends the canonical form (as produced by ToString) to b and
+// returns the extended slice, letting callers reuse a scratch buffer
+func (c *TaggedUrn) AppendTo(b []byte) []byte {
+	b = append(b, c.prefix...)
+	b = append(b, ':')
+	return c.appendTags(b)
+}
+
+// appendTags appends the canonical tag portion (everything after "prefix:") to b
+func (c *TaggedUrn) appendTags(b []byte) []byte {
+	for i, key := range c.sortedKeys() {
+		if i > 0 {
+			b = append(b, ';')
+		}
+		b = c.appendTagAt(b, key)
+	}
+	return b
+}
+
+// appendTagAt appends the tag for key, honoring preserved key=* syntax
+func (c *TaggedUrn) appendTagAt(b []byte, key string) []byte {
+	value := c.tags[key]
+	if value == "*" && c.explicitWildcards[key] {
+		b = append(b, key...)
+		return append(b, "=*"...)
+	}
+	return appendTag(b, key, value, c.literals[key])
+}
+
+// canonical returns the canonical form ignoring preserved wildcard syntax
+// Hashes and cache keys use it so that equal URNs always agree.
+func (c *TaggedUrn) canonical() string {
+	if len(c.explicitWildcards) == 0 {
+		return c.ToString()
+	}
+	return (&TaggedUrn{prefix: c.prefix, tags: c.tags, literals: c.literals}).ToString()
+}
+
+// CanonicalBytes returns the canonical form as a freshly allocated byte
+// slice, built directly without an intermediate string
+// It always uses the canonical value-less wildcard syntax, even for URNs
+// parsed with ParseOptions.PreserveWildcardSyntax, so equal URNs yield
+// equal bytes. Use AppendTo to reuse a buffer instead.
+func (c *TaggedUrn) CanonicalBytes() []byte {
+	u := c
+	if len(c.explicitWildcards) > 0 {
+		u = &TaggedUrn{prefix: c.prefix, tags: c.tags, literals: c.literals}
+	}
+	return u.AppendTo(make([]byte, 0, c.canonicalSizeHint()))
+}
+
+// canonicalTags is TagsString ignoring preserved wildcard syntax
+func (c *TaggedUrn) canonicalTags() string {
+	if len(c.explicitWildcards) == 0 {
+		return c.TagsString()
+	}
+	return (&TaggedUrn{prefix: c.prefix, tags: c.tags, literals: c.literals}).TagsString()
+}
+
+// TagsString returns the canonical tag portion without the "prefix:"
+// An empty URN returns "". Useful to recombine tags under a different
+// prefix or compare tag sets across prefixes; unlike splitting ToString on
+// ':' it is safe for quoted values containing colons.
+func (c *TaggedUrn) TagsString() string {
+	return string(c.appendTags(make([]byte, 0, c.canonicalSizeHint())))
+}
+
+// ToStringWithOrder returns the string representation with a custom key order
+// Keys listed in order are emitted first, in that order; the remaining keys
+// follow alphabetically. Listed keys that are not present (or repeated) are
+// skipped. The output parses back to an equal URN, but it is not canonical
+// unless the order happens to be alphabetical, so use ToString for hashing
+// and comparison.
+func (c *TaggedUrn) ToStringWithOrder(order []string) string {
+	b := make([]byte, 0, c.canonicalSizeHint())
+	b = append(b, c.prefix...)
+	b = append(b, ':')
+
+	emitted := make(map[string]bool, len(order))
+	first := true
+	emit := func(key string) {
+		if !first {
+			b = append(b, ';')
+		}
+		first = false
+		b = c.appendTagAt(b, key)
+		emitted[key] = true
+	}
+
+	for _, key := range order {
+		key = strings.ToLower(key)
+		if _, exists := c.tags[key]; exists && !emitted[key] {
+			emit(key)
+		}
+	}
+	for _, key := range c.sortedKeys() {
+		if !emitted[key] {
+			emit(key)
+		}
+	}
+	return string(b)
+}
+
+// SerializeOptions adjusts the string form produced by ToStringWith
+// The zero value gives the canonical form produced by ToString.
+type SerializeOptions struct {
+	// ExplicitWildcard writes must-have-any tags as key=* instead of the
+	// value-less shorthand; both forms parse to the same URN
+	ExplicitWildcard bool
+
+	// ExtraValueChars lists characters written unquoted in values; the
+	// output then needs ParseOptions.ExtraValueChars to parse
+	ExtraValueChars []rune
+}
+
+// ToStringWith returns the string representation using the given options
+// Tags stay in canonical (alphabetical) order.
+func (c *TaggedUrn) ToStringWith(opts SerializeOptions) string {
+	b := make([]byte, 0, c.canonicalSizeHint()+2*len(c.tags))
+	b = append(b, c.prefix...)
+	b = append(b, ':')
+	for i, key := range c.sortedKeys() {
+		if i > 0 {
+			b = append(b, ';')
+		}
+		value := c.tags[key]
+		if value == "*" && (opts.ExplicitWildcard || c.explicitWildcards[key]) {
+			b = append(b, key...)
+			b = append(b, "=*"...)
+			continue
+		}
+		b = appendTagWith(b, key, value, c.literals[key], opts.ExtraValueChars)
+	}
+	return string(b)
+}
+
+// ToStringMultiline returns the canonical form with one tag per line, for
+// files kept under version control where a changed tag should change one line
+// The prefix and every tag end with a newline, and every tag keeps its ';',
+// so adding or removing a tag never touches its neighbours:
+//
+//	cap:
+//	ext=pdf;
+//	op=generate;
+//
+// Removing the line breaks outside quoted values gives a parseable URN;
+// ParseMultiline does exactly that.
+func (c *TaggedUrn) ToStringMultiline() string {
+	b := make([]byte, 0, c.canonicalSizeHint()+2*len(c.tags)+1)
+	b = append(b, c.prefix...)
+	b = append(b, ":\n"...)
+	for _, key := range c.sortedKeys() {
+		b = c.appendTagAt(b, key)
+		b = append(b, ";\n"...)
+	}
+	return string(b)
+}
+
+// ToStringCompat returns the canonical form with every special value
+// written explicitly (key=*, key=? and key=!), never the value-less
+// shorthand, for parsers that do not understand bare keys
+// It is ToStringWith(SerializeOptions{ExplicitWildcard: true}) and parses
+// back to an equal URN.
+func (c *TaggedUrn) ToStringCompat() string {
+	return c.ToStringWith(SerializeOptions{ExplicitWildcard: true})
+}
+
+// ToStringNatural returns the string representation with keys in natural
+// order, so item2 precedes item10
+// Digit runs in keys compare by numeric value; other text compares
+// byte-wise. The output parses back to an equal URN but is not canonical, so
+// never hash or compare it; use ToString for that.
+func (c *TaggedUrn) ToStringNatural() string {
+	keys := c.sortedKeys()
+	sort.SliceStable(keys, func(i, j int) bool {
+		return naturalLess(keys[i], keys[j])
+	})
+	return c.ToStringWithOrder(keys)
 }
 
-// Subset returns a new URN with only specified tags
-func (c *TaggedUrn) Subset(keys []string) *TaggedUrn {
-	newTags := make(map[string]string)
-	for _, key := range keys {
-		if value, exists := c.tags[key]; exists {
-			newTags[key] = value
+// naturalLess orders strings comparing digit runs numerically
+// Runs equal in value (e.g. "01" and "1") fall back to byte order, keeping
+// the order total.
+func naturalLess(a, b string) bool {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		ca, cb := a[i], b[j]
+		if isASCIIDigit(ca) && isASCIIDigit(cb) {
+			si, sj := i, j
+			for i < len(a) && isASCIIDigit(a[i]) {
+				i++
+			}
+			for j < len(b) && isASCIIDigit(b[j]) {
+				j++
+			}
+			na := strings.TrimLeft(a[si:i], "0")
+			nb := strings.TrimLeft(b[sj:j], "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			continue
 		}
+		if ca != cb {
+			return ca < cb
+		}
+		i++
+		j++
+	}
+	if len(a)-i != len(b)-j {
+		return len(a)-i < len(b)-j
 	}
-	return &TaggedUrn{prefix: c.prefix, tags: newTags}
+	return a < b
 }
 
-// Merge returns a new URN merged with another (other takes precedence for conflicts)
-// Both must have the same prefix
-func (c *TaggedUrn) Merge(other *TaggedUrn) (*TaggedUrn, error) {
-	if other == nil {
-		return nil, &TaggedUrnError{
-			Code:    ErrorInvalidFormat,
-			Message: "cannot merge with nil URN",
+// isASCIIDigit checks for '0' through '9'
+func isASCIIDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Pretty returns a multi-line, column-aligned representation for logs and CLIs
+// The prefix line is followed by one indented "key = value" line per tag in
+// canonical order, with keys padded to equal width and special values
+// written out explicitly:
+//
+//	cap:
+//	  ext     = pdf
+//	  op      = generate
+//	  quality = *
+//
+// It is not a wire format; use ToString for that.
+func (c *TaggedUrn) Pretty() string {
+	keys := c.sortedKeys()
+	width := 0
+	for _, key := range keys {
+		if n := utf8.RuneCountInString(key); n > width {
+			width = n
 		}
 	}
 
-	if c.prefix != other.prefix {
-		return nil, &TaggedUrnError{
-			Code:    ErrorPrefixMismatch,
-			Message: fmt.Sprintf("cannot merge URNs with different prefixes: '%s' vs '%s'", c.prefix, other.prefix),
+	var b strings.Builder
+	b.WriteString(c.prefix)
+	b.WriteByte(':')
+	for _, key := range keys {
+		b.WriteString("\n  ")
+		b.WriteString(key)
+		b.WriteString(strings.Repeat(" ", width-utf8.RuneCountInString(key)))
+		b.WriteString(" = ")
+		value := c.tags[key]
+		if c.literals[key] || needsQuoting(value) {
+			b.Write(appendQuoted(nil, value))
+		} else {
+			b.WriteString(value)
 		}
 	}
+	return b.String()
+}
 
-	newTags := make(map[string]string)
-	for k, v := range c.tags {
-		newTags[k] = v
+// WriteTo implements io.WriterTo, streaming the canonical form (as produced
+// by ToString) to w one tag at a time without building the whole string
+func (c *TaggedUrn) WriteTo(w io.Writer) (int64, error) {
+	var scratch [64]byte
+	buf := append(scratch[:0], c.prefix...)
+	buf = append(buf, ':')
+
+	var total int64
+	for i, key := range c.sortedKeys() {
+		if i > 0 {
+			buf = append(buf, ';')
+		}
+		buf = c.appendTagAt(buf, key)
+		n, err := w.Write(buf)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+		buf = buf[:0]
 	}
-	for k, v := range other.tags {
-		newTags[k] = v
+	if len(buf) > 0 {
+		n, err := w.Write(buf)
+		total += int64(n)
+		return total, err
 	}
-	return &TaggedUrn{prefix: c.prefix, tags: newTags}, nil
+	return total, nil
 }
 
-// ToString returns the canonical string representation of this tagged URN
-// Uses the stored prefix
-// Tags are sorted alphabetically for consistent representation
-// No trailing semicolon in canonical form
-// Values are quoted only when necessary (smart quoting)
-// Special value serialization:
-// - * (must-have-any): serialized as value-less tag (just the key)
-// - ? (unspecified): serialized as key=?
-// - ! (must-not-have): serialized as key=!
-func (c *TaggedUrn) ToString() string {
-	if len(c.tags) == 0 {
-		return fmt.Sprintf("%s:", c.prefix)
-	}
-
-	// Sort keys for canonical representation
+// sortedKeys returns the tag keys in canonical (alphabetical) order
+func (c *TaggedUrn) sortedKeys() []string {
 	keys := make([]string, 0, len(c.tags))
 	for key := range c.tags {
 		keys = append(keys, key)
 	}
 	sort.Strings(keys)
+	return keys
+}
 
-	// Build tag string with smart quoting
-	parts := make([]string, 0, len(keys))
-	for _, key := range keys {
-		value := c.tags[key]
-		switch value {
-		case "*":
-			// Valueless sugar: key
-			parts = append(parts, key)
-		case "?":
-			// Explicit: key=?
-			parts = append(parts, fmt.Sprintf("%s=?", key))
-		case "!":
-			// Explicit: key=!
-			parts = append(parts, fmt.Sprintf("%s=!", key))
-		default:
-			if needsQuoting(value) {
-				parts = append(parts, fmt.Sprintf("%s=%s", key, quoteValue(value)))
-			} else {
-				parts = append(parts, fmt.Sprintf("%s=%s", key, value))
-			}
-		}
+// canonicalSizeHint estimates the length of the canonical form
+func (c *TaggedUrn) canonicalSizeHint() int {
+	n := len(c.prefix) + 1
+	for key, value := range c.tags {
+		n += len(key) + len(value) + 4 // '=', ';' and a possible pair of quotes
 	}
-
-	tagsStr := strings.Join(parts, ";")
-	return fmt.Sprintf("%s:%s", c.prefix, tagsStr)
+	return n
 }
 
 // String implements the Stringer interface
@@ -862,13 +3186,23 @@ func (c *TaggedUrn) Equals(other *TaggedUrn) bool {
 		return false
 	}
 
+	return c.TagsEqual(other)
+}
+
+// TagsEqual checks if this tagged URN has exactly the same tags as another,
+// ignoring their prefixes
+func (c *TaggedUrn) TagsEqual(other *TaggedUrn) bool {
+	if other == nil {
+		return false
+	}
+
 	if len(c.tags) != len(other.tags) {
 		return false
 	}
 
 	for key, value := range c.tags {
 		otherValue, exists := other.tags[key]
-		if !exists || value != otherValue {
+		if !exists || value != otherValue || c.literals[key] != other.literals[key] {
 			return false
 		}
 	}
@@ -876,15 +3210,76 @@ func (c *TaggedUrn) Equals(other *TaggedUrn) bool {
 	return true
 }
 
+// Compare orders URNs by prefix, then by canonical tag string
+// It returns -1, 0 or +1 and is 0 exactly when the URNs are Equals, giving a
+// deterministic total order for sorting.
+func (c *TaggedUrn) Compare(other *TaggedUrn) int {
+	if c.prefix != other.prefix {
+		return strings.Compare(c.prefix, other.prefix)
+	}
+	return strings.Compare(c.canonicalTags(), other.canonicalTags())
+}
+
+// SortKey returns a string whose byte-wise order matches Compare
+// It is the prefix and the canonical tags joined by a NUL byte rather than
+// ':', since prefix characters such as '-' or '.' sort below ':' and would
+// otherwise break the ordering between prefixes like "ca" and "ca-x". Equal
+// URNs have equal sort keys, so it can back an indexed column. It is not a
+// parseable URN; use ToString for that.
+func (c *TaggedUrn) SortKey() string {
+	return c.prefix + "\x00" + c.canonicalTags()
+}
+
+// PrefixMismatchDistance is the Distance between URNs with different prefixes
+// It exceeds any tag-level distance between realistic URNs, so a candidate
+// under another prefix is never the nearest.
+const PrefixMismatchDistance = 1 << 30
+
+// Distance counts the tag-level edits turning this URN into other
+// A key present on only one side (an insert or delete) costs 1, as does a
+// key whose values differ; values compare as stored, so ext=* and ext=pdf
+// differ. URNs with different prefixes are PrefixMismatchDistance apart.
+// The distance is symmetric and 0 exactly when the URNs are Equals.
+func (c *TaggedUrn) Distance(other *TaggedUrn) int {
+	if c.prefix != other.prefix {
+		return PrefixMismatchDistance
+	}
+	distance := 0
+	for key, value := range c.tags {
+		if otherValue, exists := other.tags[key]; !exists || otherValue != value || c.literals[key] != other.literals[key] {
+			distance++
+		}
+	}
+	for key := range other.tags {
+		if _, exists := c.tags[key]; !exists {
+			distance++
+		}
+	}
+	return distance
+}
+
 // Hash returns a hash of this tagged URN
 // Two equivalent tagged URNs will have the same hash
 func (c *TaggedUrn) Hash() string {
 	// Use canonical string representation for consistent hashing
-	canonical := c.ToString()
-	h := sha256.Sum256([]byte(canonical))
+	h := sha256.Sum256(c.CanonicalBytes())
 	return fmt.Sprintf("%x", h)
 }
 
+// Fingerprint returns a hash like Hash, computed with the given keys removed
+// Useful as a cache key for URNs that differ only in volatile tags such as
+// a timestamp or request id. Keys are case-insensitive; with no keys it
+// equals Hash.
+func (c *TaggedUrn) Fingerprint(ignoreKeys ...string) string {
+	stripped := c
+	for _, key := range ignoreKeys {
+		if _, exists := stripped.tags[strings.ToLower(key)]; exists {
+			stripped = stripped.WithoutTag(key)
+		}
+	}
+	return stripped.Hash()
+}
+
 // MarshalJSON implements the json.Marshaler interface
 func (c *TaggedUrn) MarshalJSON() ([]byte, error) {
 	return json.Marshal(c.ToString())
@@ -902,8 +3297,73 @@ func (c *TaggedUrn) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
+	if c.frozen {
+		return &TaggedUrnError{
+			Code:    ErrorFrozenUrn,
+			Message: fmt.Sprintf("cannot unmarshal into frozen URN '%s'", c.ToString()),
+		}
+	}
 	c.prefix = taggedUrn.prefix
 	c.tags = taggedUrn.tags
+	c.explicitWildcards = nil
+	c.literals = taggedUrn.literals
+	return nil
+}
+
+// Freeze marks this URN read-only and returns it
+// URNs never change through their methods anyway; freezing additionally
+// makes UnmarshalJSON into this URN fail with ErrorFrozenUrn, guarding a URN
+// held in a shared registry against being decoded over by accident. Freeze
+// before sharing the URN between goroutines. URNs derived from a frozen URN
+// are not frozen.
+func (c *TaggedUrn) Freeze() *TaggedUrn {
+	c.frozen = true
+	return c
+}
+
+// IsFrozen reports whether Freeze has been called on this URN
+func (c *TaggedUrn) IsFrozen() bool {
+	return c.frozen
+}
+
+// SamePrefix checks that all URNs share one prefix and returns it
+// An empty slice returns an empty prefix. A nil entry or the first URN whose
+// prefix differs from urns[0] is reported by index.
+func SamePrefix(urns []*TaggedUrn) (string, error) {
+	prefix := ""
+	for i, urn := range urns {
+		if urn == nil {
+			return "", &TaggedUrnError{
+				Code:    ErrorInvalidFormat,
+				Message: fmt.Sprintf("URN at index %d is nil", i),
+			}
+		}
+		if i == 0 {
+			prefix = urn.prefix
+			continue
+		}
+		if urn.prefix != prefix {
+			return "", &TaggedUrnError{
+				Code:    ErrorPrefixMismatch,
+				Message: fmt.Sprintf("URN at index %d has prefix '%s', expected '%s'", i, urn.prefix, prefix),
+			}
+		}
+	}
+	return prefix, nil
+}
+
+// checkCandidatePrefixes verifies candidates share the request's prefix before matching
+func checkCandidatePrefixes(urns []*TaggedUrn, request *TaggedUrn) error {
+	prefix, err := SamePrefix(urns)
+	if err != nil {
+		return err
+	}
+	if len(urns) > 0 && request != nil && request.prefix != prefix {
+		return &TaggedUrnError{
+			Code:    ErrorPrefixMismatch,
+			Message: fmt.Sprintf("request has prefix '%s' but candidates have prefix '%s'", request.prefix, prefix),
+		}
+	}
 	return nil
 }
 
@@ -913,8 +3373,175 @@ type UrnMatcher struct{}
 // FindBestMatch finds the most specific URN that conforms to a request's constraints.
 // URNs are instances (capabilities), request is the pattern (requirement).
 func (m *UrnMatcher) FindBestMatch(urns []*TaggedUrn, request *TaggedUrn) (*TaggedUrn, error) {
-	var best *TaggedUrn
+	return m.FindBestMatchContext(context.Background(), urns, request)
+}
+
+// contextCheckInterval is how many candidates FindBestMatchContext scans
+// between checks of its context
+const contextCheckInterval = 64
+
+// FindBestMatchContext is FindBestMatch that gives up when ctx is done
+// The context is checked before the scan and every contextCheckInterval
+// candidates, so a cancelled or expired context aborts a large scan with
+// ctx.Err() instead of running to completion.
+func (m *UrnMatcher) FindBestMatchContext(ctx context.Context, urns []*TaggedUrn, request *TaggedUrn) (*TaggedUrn, error) {
+	if err := checkCandidatePrefixes(urns, request); err != nil {
+		return nil, err
+	}
+	best, err := bestMatchIndex(ctx, len(urns), func(i int) *TaggedUrn { return urns[i] }, request)
+	if err != nil || best < 0 {
+		return nil, err
+	}
+	return urns[best], nil
+}
+
+// bestMatchIndex returns the index of the most specific of n candidates
+// conforming to request, or -1 when none does
+// It holds the tie-break shared by every FindBestMatch variant: on equal
+// Specificity the earliest candidate wins.
+func bestMatchIndex(ctx context.Context, n int, candidate func(i int) *TaggedUrn, request *TaggedUrn) (int, error) {
+	best := -1
+	bestSpecificity := -1
+	for i := 0; i < n; i++ {
+		if i%contextCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return -1, err
+			}
+		}
+		urn := candidate(i)
+		ok, err := urn.ConformsTo(request)
+		if err != nil {
+			return -1, err
+		}
+		if ok {
+			if specificity := urn.Specificity(); specificity > bestSpecificity {
+				best = i
+				bestSpecificity = specificity
+			}
+		}
+	}
+	return best, nil
+}
+
+// FindBestMatchWeighted picks randomly among the most specific URNs that
+// conform to a request, for load balancing across equivalent handlers
+// Each top-specificity match is chosen with probability proportional to
+// weight(urn); a nil weight function gives equal weights, and weights below
+// 0 count as 0. If every weight is 0 the choice is uniform. The choice is
+// deterministic for a seeded rng; a nil rng uses the math/rand global
+// source. Returns nil when nothing matches.
+func (m *UrnMatcher) FindBestMatchWeighted(urns []*TaggedUrn, request *TaggedUrn, rng *rand.Rand, weight func(*TaggedUrn) int) (*TaggedUrn, error) {
+	if err := checkCandidatePrefixes(urns, request); err != nil {
+		return nil, err
+	}
+
+	// Collect the top-specificity matches in slice order
+	var top []*TaggedUrn
 	bestSpecificity := -1
+	for _, urn := range urns {
+		ok, err := urn.ConformsTo(request)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		switch specificity := urn.Specificity(); {
+		case specificity > bestSpecificity:
+			top = append(top[:0], urn)
+			bestSpecificity = specificity
+		case specificity == bestSpecificity:
+			top = append(top, urn)
+		}
+	}
+	if len(top) == 0 {
+		return nil, nil
+	}
+
+	intn := rand.Intn
+	if rng != nil {
+		intn = rng.Intn
+	}
+
+	weights := make([]int, len(top))
+	total := 0
+	for i, urn := range top {
+		weights[i] = 1
+		if weight != nil {
+			weights[i] = max(weight(urn), 0)
+		}
+		total += weights[i]
+	}
+	if total == 0 {
+		return top[intn(len(top))], nil
+	}
+
+	pick := intn(total)
+	for i, w := range weights {
+		if pick < w {
+			return top[i], nil
+		}
+		pick -= w
+	}
+	return top[len(top)-1], nil
+}
+
+// MatchAttempt records how a single candidate fared against a request
+type MatchAttempt struct {
+	Urn     *TaggedUrn
+	Matched bool
+	// Reason explains a rejection (see MatchesExplain); empty when Matched
+	Reason string
+}
+
+// Explain reports, for every candidate in order, whether it conforms to the
+// request and why not when it does not
+// Unlike FindBestMatch it never fails: a nil candidate or a prefix mismatch
+// is reported as that candidate's Reason.
+func (m *UrnMatcher) Explain(urns []*TaggedUrn, request *TaggedUrn) []MatchAttempt {
+	attempts := make([]MatchAttempt, 0, len(urns))
+	for _, urn := range urns {
+		attempt := MatchAttempt{Urn: urn}
+		if urn == nil {
+			attempt.Reason = "candidate is nil"
+		} else {
+			matched, reason, err := urn.MatchesExplain(request)
+			attempt.Matched = matched
+			attempt.Reason = reason
+			if err != nil {
+				attempt.Reason = err.Error()
+			}
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts
+}
+
+// FindBestMatchWithWeights finds the conforming URN with the highest WeightedSpecificity.
+// URNs are instances (capabilities), request is the pattern (requirement).
+// On ties the earliest URN in the slice wins.
+func (m *UrnMatcher) FindBestMatchWithWeights(urns []*TaggedUrn, request *TaggedUrn, weights map[string]int) (*TaggedUrn, error) {
+	weights = lowercaseKeys(weights)
+	return m.FindBestMatchFunc(urns, request, func(urn *TaggedUrn) int {
+		return urn.weightedSpecificity(weights)
+	})
+}
+
+// FindBestMatchFunc finds the conforming URN with the highest score according to a custom scorer.
+// URNs are instances (capabilities), request is the pattern (requirement).
+// Candidates are first filtered by ConformsTo, then ranked by score.
+// On ties the earliest URN in the slice wins, so the result is deterministic.
+// A nil score function ranks by Specificity.
+func (m *UrnMatcher) FindBestMatchFunc(urns []*TaggedUrn, request *TaggedUrn, score func(*TaggedUrn) int) (*TaggedUrn, error) {
+	if score == nil {
+		score = (*TaggedUrn).Specificity
+	}
+	if err := checkCandidatePrefixes(urns, request); err != nil {
+		return nil, err
+	}
+
+	var best *TaggedUrn
+	bestScore := 0
 
 	for _, urn := range urns {
 		ok, err := urn.ConformsTo(request)
@@ -922,10 +3549,10 @@ func (m *UrnMatcher) FindBestMatch(urns []*TaggedUrn, request *TaggedUrn) (*Tagg
 			return nil, err
 		}
 		if ok {
-			specificity := urn.Specificity()
-			if specificity > bestSpecificity {
+			s := score(urn)
+			if best == nil || s > bestScore {
 				best = urn
-				bestSpecificity = specificity
+				bestScore = s
 			}
 		}
 	}
@@ -936,6 +3563,10 @@ func (m *UrnMatcher) FindBestMatch(urns []*TaggedUrn, request *TaggedUrn) (*Tagg
 // FindAllMatches finds all URNs that conform to a request's constraints, sorted by specificity.
 // URNs are instances (capabilities), request is the pattern (requirement).
 func (m *UrnMatcher) FindAllMatches(urns []*TaggedUrn, request *TaggedUrn) ([]*TaggedUrn, error) {
+	if err := checkCandidatePrefixes(urns, request); err != nil {
+		return nil, err
+	}
+
 	var results []*TaggedUrn
 
 	for _, urn := range urns {
@@ -956,6 +3587,104 @@ func (m *UrnMatcher) FindAllMatches(urns []*TaggedUrn, request *TaggedUrn) ([]*T
 	return results, nil
 }
 
+// FindMatchesByTier finds all URNs that conform to a request, grouped by specificity
+// Each inner slice holds the matches sharing one Specificity score, with the
+// most specific tier first. Within a tier URNs are ordered by
+// SpecificityTuple (more exact tags first, then more must-have-any tags,
+// then more must-not-have tags) and finally by canonical string, so the
+// result is deterministic. Returns nil when nothing matches.
+func (m *UrnMatcher) FindMatchesByTier(urns []*TaggedUrn, request *TaggedUrn) ([][]*TaggedUrn, error) {
+	matches, err := m.FindAllMatches(urns, request)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if sa, sb := a.Specificity(), b.Specificity(); sa != sb {
+			return sa > sb
+		}
+		aExact, aAny, aNot := a.SpecificityTuple()
+		bExact, bAny, bNot := b.SpecificityTuple()
+		if aExact != bExact {
+			return aExact > bExact
+		}
+		if aAny != bAny {
+			return aAny > bAny
+		}
+		if aNot != bNot {
+			return aNot > bNot
+		}
+		return a.canonical() < b.canonical()
+	})
+
+	var tiers [][]*TaggedUrn
+	for i, urn := range matches {
+		if i == 0 || urn.Specificity() != matches[i-1].Specificity() {
+			tiers = append(tiers, nil)
+		}
+		tiers[len(tiers)-1] = append(tiers[len(tiers)-1], urn)
+	}
+	return tiers, nil
+}
+
+// FindNearest finds the candidate with the smallest Distance to the request
+// It is the fallback when FindBestMatch returns nil, e.g. to report "no
+// exact capability; closest is X, differing by 2 tags". Ties go to the
+// candidate with the smaller canonical string. Candidates must share the
+// request's prefix. With no candidates it returns nil and -1.
+func (m *UrnMatcher) FindNearest(urns []*TaggedUrn, request *TaggedUrn) (*TaggedUrn, int, error) {
+	if request == nil {
+		return nil, -1, &TaggedUrnError{
+			Code:    ErrorInvalidFormat,
+			Message: "cannot match against nil request",
+		}
+	}
+	if err := checkCandidatePrefixes(urns, request); err != nil {
+		return nil, -1, err
+	}
+
+	var nearest *TaggedUrn
+	nearestDistance := -1
+	for _, urn := range urns {
+		distance := urn.Distance(request)
+		if nearest == nil || distance < nearestDistance ||
+			(distance == nearestDistance && urn.canonical() < nearest.canonical()) {
+			nearest = urn
+			nearestDistance = distance
+		}
+	}
+	return nearest, nearestDistance, nil
+}
+
+// SatisfiesAll checks that an instance conforms to every policy pattern (AND)
+// It returns the first failing policy in order for diagnostics, or nil when
+// all are satisfied. Policies must be non-nil and share the instance's
+// prefix; otherwise ErrorInvalidFormat or ErrorPrefixMismatch is returned.
+// An empty policy set is satisfied.
+func (m *UrnMatcher) SatisfiesAll(instance *TaggedUrn, policies []*TaggedUrn) (bool, *TaggedUrn, error) {
+	if instance == nil {
+		return false, nil, &TaggedUrnError{
+			Code:    ErrorInvalidFormat,
+			Message: "cannot match nil instance",
+		}
+	}
+	if err := checkCandidatePrefixes(policies, instance); err != nil {
+		return false, nil, err
+	}
+
+	for _, policy := range policies {
+		ok, err := instance.ConformsTo(policy)
+		if err != nil {
+			return false, nil, err
+		}
+		if !ok {
+			return false, policy, nil
+		}
+	}
+	return true, nil, nil
+}
+
 // AreCompatible checks if two URN sets are compatible
 // Two URNs are compatible if either accepts the other (bidirectional accepts)
 func (m *UrnMatcher) AreCompatible(urns1, urns2 []*TaggedUrn) (bool, error) {
@@ -985,18 +3714,20 @@ type TaggedUrnBuilder struct {
 	prefix string
 	tags   map[string]string
 	err    error
+	opts   *ParseOptions
 }
 
 // NewTaggedUrnBuilder creates a new builder with a specified prefix (required)
 func NewTaggedUrnBuilder(prefix string) *TaggedUrnBuilder {
 	return &TaggedUrnBuilder{
-		prefix: strings.ToLower(prefix),
+		prefix: NormalizePrefix(prefix),
 		tags:   make(map[string]string),
 	}
 }
 
 // Tag adds or updates a tag
-// Key is normalized to lowercase; value is preserved as-is
+// Key is normalized to lowercase; value is preserved as-is, and a "/.../"
+// value stays a literal (see TaggedUrn.WithRegexTag)
 // Tracks error if value is empty (use SoloTag for wildcard)
 // Error is returned at Build() time
 func (b *TaggedUrnBuilder) Tag(key, value string) *TaggedUrnBuilder {
@@ -1021,6 +3752,39 @@ func (b *TaggedUrnBuilder) SoloTag(key string) *TaggedUrnBuilder {
 	return b
 }
 
+// Remove deletes a previously added tag, if present
+// Key is normalized to lowercase
+func (b *TaggedUrnBuilder) Remove(key string) *TaggedUrnBuilder {
+	delete(b.tags, strings.ToLower(key))
+	return b
+}
+
+// Has checks whether a tag has been added
+// Key is normalized to lowercase
+func (b *TaggedUrnBuilder) Has(key string) bool {
+	_, exists := b.tags[strings.ToLower(key)]
+	return exists
+}
+
+// Flag adds a boolean tag, stored as "true" or "false"
+// Key is normalized to lowercase
+func (b *TaggedUrnBuilder) Flag(key string, on bool) *TaggedUrnBuilder {
+	return b.Tag(key, strconv.FormatBool(on))
+}
+
+// WithOptions makes Build check and normalize the tags as a parser configured
+// with opts would: keys must use the allowed key characters (StrictKeyChars,
+// ExtraKeyChars) and not be purely numeric, and NormalizeUnicode and
+// ForceLowercaseValues apply. Values are otherwise kept as given, like
+// WithTag values: nothing is expanded, so "$title" stays literal, @enum and
+// ~v keep their pattern meaning and a "/.../" value stays a literal.
+// Without WithOptions, Build performs no validation beyond rejecting empty
+// values.
+func (b *TaggedUrnBuilder) WithOptions(opts ParseOptions) *TaggedUrnBuilder {
+	b.opts = &opts
+	return b
+}
+
 // Build creates the final TaggedUrn
 func (b *TaggedUrnBuilder) Build() (*TaggedUrn, error) {
 	// Check for errors accumulated during building
@@ -1035,10 +3799,71 @@ func (b *TaggedUrnBuilder) Build() (*TaggedUrn, error) {
 		}
 	}
 
-	return &TaggedUrn{prefix: b.prefix, tags: b.tags}, nil
+	if err := checkReservedPrefix(b.prefix); err != nil {
+		return nil, err
+	}
+
+	urn := b.BuildAllowEmpty()
+	if b.opts != nil {
+		return urn.applyOptions(*b.opts)
+	}
+	return urn, nil
+}
+
+// applyOptions checks and normalizes built tags under opts, in canonical key order
+func (c *TaggedUrn) applyOptions(opts ParseOptions) (*TaggedUrn, error) {
+	if err := opts.checkExtraChars(); err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string, len(c.tags))
+	for _, key := range c.sortedKeys() {
+		value := c.tags[key]
+		if opts.ForceLowercaseValues {
+			value = strings.ToLower(value)
+		}
+		if opts.NormalizeUnicode {
+			key = norm.NFC.String(key)
+			value = norm.NFC.String(value)
+		}
+		if err := validateKeyWith(key, opts); err != nil {
+			return nil, err
+		}
+		if _, exists := tags[key]; exists {
+			return nil, &TaggedUrnError{
+				Code:    ErrorDuplicateKey,
+				Message: fmt.Sprintf("duplicate tag key: %s", key),
+			}
+		}
+		tags[key] = value
+	}
+	return &TaggedUrn{prefix: c.prefix, tags: tags, literals: codeLiterals(tags)}, nil
 }
 
 // BuildAllowEmpty creates the final TaggedUrn, allowing empty tags
+// It cannot fail, so unlike Build it skips the reserved prefix check.
 func (b *TaggedUrnBuilder) BuildAllowEmpty() *TaggedUrn {
-	return &TaggedUrn{prefix: b.prefix, tags: b.tags}
+	tags := b.copyTags()
+	return &TaggedUrn{prefix: b.prefix, tags: tags, literals: codeLiterals(tags)}
+}
+
+// copyTags returns a right-sized copy of the tags, so URNs already built are
+// unaffected by later Tag, Reset or other builder calls
+func (b *TaggedUrnBuilder) copyTags() map[string]string {
+	tags := make(map[string]string, len(b.tags))
+	for k, v := range b.tags {
+		tags[k] = v
+	}
+	return tags
+}
+
+// Reset clears the builder for reuse with a new prefix, keeping the tag
+// map's allocation
+// Any error recorded by Tag is cleared; options set by WithOptions are kept.
+// A builder is not safe for concurrent use, so a reused builder must not be
+// shared between goroutines.
+func (b *TaggedUrnBuilder) Reset(prefix string) *TaggedUrnBuilder {
+	b.prefix = NormalizePrefix(prefix)
+	clear(b.tags)
+	b.err = nil
+	return b
 }
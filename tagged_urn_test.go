@@ -1,8 +1,17 @@
 package taggedurn
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -446,6 +455,53 @@ func TestMergePrefixMismatch(t *testing.T) {
 	assert.Equal(t, ErrorPrefixMismatch, capError.Code)
 }
 
+func TestWithDefaults(t *testing.T) {
+	defaults, err := NewTaggedUrnFromString("cap:ext=pdf;quality=high;draft=!")
+	require.NoError(t, err)
+
+	urn, err := NewTaggedUrnFromString("cap:op=generate;quality=low;draft")
+	require.NoError(t, err)
+
+	filled, err := urn.WithDefaults(defaults)
+	require.NoError(t, err)
+	assert.Equal(t, "cap:draft;ext=pdf;op=generate;quality=low", filled.ToString())
+	assert.Equal(t, "cap:draft;op=generate;quality=low", urn.ToString())
+
+	other, err := NewTaggedUrnFromString("myapp:ext=pdf")
+	require.NoError(t, err)
+	_, err = urn.WithDefaults(other)
+	require.Error(t, err)
+	assert.Equal(t, ErrorPrefixMismatch, err.(*TaggedUrnError).Code)
+
+	_, err = urn.WithDefaults(nil)
+	assert.Error(t, err)
+}
+
+func TestRedundantTags(t *testing.T) {
+	base, err := NewTaggedUrnFromString("cap:op=generate;ext=pdf;quality=high;draft")
+	require.NoError(t, err)
+
+	overrides, err := NewTaggedUrnFromString("cap:ext=pdf;quality=low;draft;target=thumbnail")
+	require.NoError(t, err)
+
+	redundant, err := overrides.RedundantTags(base)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"draft", "ext"}, redundant)
+
+	none, err := Empty("cap").RedundantTags(base)
+	require.NoError(t, err)
+	assert.Empty(t, none)
+
+	other, err := NewTaggedUrnFromString("myapp:ext=pdf")
+	require.NoError(t, err)
+	_, err = overrides.RedundantTags(other)
+	require.Error(t, err)
+	assert.Equal(t, ErrorPrefixMismatch, err.(*TaggedUrnError).Code)
+
+	_, err = overrides.RedundantTags(nil)
+	assert.Error(t, err)
+}
+
 func TestEquality(t *testing.T) {
 	urn1, err := NewTaggedUrnFromString("cap:op=generate")
 	require.NoError(t, err)
@@ -1765,6 +1821,19 @@ func TestSpecificityWithSpecialValues(t *testing.T) {
 	assert.Equal(t, 1, e)
 	assert.Equal(t, 1, mha)
 	assert.Equal(t, 1, mn)
+
+	// Tuples group kinds as Specificity scores them
+	RegisterEnum("tuple-rgb", []string{"red", "green", "blue"})
+	patterns, _ := NewTaggedUrnFromString(`cap:a=@tuple-rgb;b=~x;c=/^y/;d="@tuple-rgb"`)
+	e, mha, mn = patterns.SpecificityTuple()
+	assert.Equal(t, 2, e)
+	assert.Equal(t, 2, mha)
+	assert.Equal(t, 0, mn)
+	enum, _ := NewTaggedUrnFromString("cap:c=@tuple-rgb")
+	presence, _ := NewTaggedUrnFromString("cap:c")
+	e, mha, mn = enum.SpecificityTuple()
+	e2, mha2, mn2 := presence.SpecificityTuple()
+	assert.Equal(t, []int{e2, mha2, mn2}, []int{e, mha, mn})
 }
 
 // =========================================================================
@@ -2089,3 +2158,2373 @@ func Test_BuilderRejectsEmptyValue(t *testing.T) {
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "empty value")
 }
+
+func TestWeightedSpecificity(t *testing.T) {
+	urn, err := NewTaggedUrnFromString("cap:op=generate;ext=pdf;debug=!")
+	require.NoError(t, err)
+
+	// nil weights behave like plain Specificity
+	assert.Equal(t, urn.Specificity(), urn.WeightedSpecificity(nil))
+	// op exact (3*10) + ext exact (3*1) + debug must-not (1*1)
+	assert.Equal(t, 34, urn.WeightedSpecificity(map[string]int{"op": 10}))
+	// zero weight removes a dimension entirely
+	assert.Equal(t, 4, urn.WeightedSpecificity(map[string]int{"op": 0}))
+	// plain Specificity is unaffected
+	assert.Equal(t, 7, urn.Specificity())
+	// weight keys match tag keys case-insensitively
+	assert.Equal(t, 34, urn.WeightedSpecificity(map[string]int{"Op": 10}))
+	// an all-lowercase key wins over one differing only in case
+	assert.Equal(t, 34, urn.WeightedSpecificity(map[string]int{"OP": 0, "op": 10}))
+}
+
+func TestFindBestMatchWithWeights(t *testing.T) {
+	matcher := &UrnMatcher{}
+	opExact, _ := NewTaggedUrnFromString("cap:op=generate;ext")
+	extAndTarget, _ := NewTaggedUrnFromString("cap:ext=pdf;target=thumbnail;op")
+	request, _ := NewTaggedUrnFromString("cap:op=generate;ext=pdf")
+
+	// Unweighted: ext=pdf;target=thumbnail;op scores 8 vs 5
+	best, err := matcher.FindBestMatch([]*TaggedUrn{opExact, extAndTarget}, request)
+	require.NoError(t, err)
+	assert.Same(t, extAndTarget, best)
+
+	// Weighting op heavily prefers the candidate that nails op
+	best, err = matcher.FindBestMatchWithWeights([]*TaggedUrn{opExact, extAndTarget}, request, map[string]int{"op": 10})
+	require.NoError(t, err)
+	assert.Same(t, opExact, best)
+
+	// No conforming candidate
+	other, _ := NewTaggedUrnFromString("cap:op=extract")
+	best, err = matcher.FindBestMatchWithWeights([]*TaggedUrn{other}, request, nil)
+	require.NoError(t, err)
+	assert.Nil(t, best)
+}
+
+func TestFindBestMatchFunc(t *testing.T) {
+	matcher := &UrnMatcher{}
+	broad, _ := NewTaggedUrnFromString("cap:op=generate")
+	narrow, _ := NewTaggedUrnFromString("cap:op=generate;ext=pdf;target=thumbnail")
+	forbidding, _ := NewTaggedUrnFromString("cap:op=generate;debug=!")
+	other, _ := NewTaggedUrnFromString("cap:op=extract")
+	request, _ := NewTaggedUrnFromString("cap:op=generate")
+	urns := []*TaggedUrn{other, broad, narrow, forbidding}
+
+	// Prefer fewer tags
+	fewest := func(urn *TaggedUrn) int { return -len(urn.AllTags()) }
+	best, err := matcher.FindBestMatchFunc(urns, request, fewest)
+	require.NoError(t, err)
+	assert.Same(t, broad, best)
+
+	// Prefer must-not constraints
+	mustNot := func(urn *TaggedUrn) int {
+		_, _, n := urn.SpecificityTuple()
+		return n
+	}
+	best, err = matcher.FindBestMatchFunc(urns, request, mustNot)
+	require.NoError(t, err)
+	assert.Same(t, forbidding, best)
+
+	// Ties resolve to the earliest candidate
+	constant := func(*TaggedUrn) int { return 0 }
+	best, err = matcher.FindBestMatchFunc(urns, request, constant)
+	require.NoError(t, err)
+	assert.Same(t, broad, best)
+
+	// nil scorer falls back to Specificity
+	best, err = matcher.FindBestMatchFunc(urns, request, nil)
+	require.NoError(t, err)
+	assert.Same(t, narrow, best)
+
+	// Prefix mismatch surfaces as an error
+	media, _ := NewTaggedUrnFromString("media:")
+	_, err = matcher.FindBestMatchFunc([]*TaggedUrn{media}, request, fewest)
+	assert.Error(t, err)
+}
+
+func TestTighten(t *testing.T) {
+	urn, err := NewTaggedUrnFromString("cap:op=generate;ext;target=?;debug=!")
+	require.NoError(t, err)
+
+	// * becomes concrete
+	tightened, err := urn.Tighten("ext", "pdf")
+	require.NoError(t, err)
+	assert.Equal(t, "cap:debug=!;ext=pdf;op=generate;target=?", tightened.ToString())
+
+	// ? becomes concrete
+	tightened, err = tightened.Tighten("target", "thumbnail")
+	require.NoError(t, err)
+	assert.Equal(t, "cap:debug=!;ext=pdf;op=generate;target=thumbnail", tightened.ToString())
+
+	// missing tag is added, key is lowercased
+	tightened, err = tightened.Tighten("Format", "binary")
+	require.NoError(t, err)
+	assert.True(t, tightened.HasTag("format", "binary"))
+
+	// same concrete value is a no-op
+	same, err := tightened.Tighten("op", "generate")
+	require.NoError(t, err)
+	assert.True(t, same.Equals(tightened))
+
+	// original is unchanged
+	assert.Equal(t, "cap:debug=!;ext;op=generate;target=?", urn.ToString())
+}
+
+func TestTightenRejectsContradictions(t *testing.T) {
+	urn, err := NewTaggedUrnFromString("cap:op=generate;debug=!;ext")
+	require.NoError(t, err)
+
+	_, err = urn.Tighten("op", "extract")
+	require.Error(t, err)
+	assert.Equal(t, ErrorIncompatibleTighten, err.(*TaggedUrnError).Code)
+
+	_, err = urn.Tighten("debug", "true")
+	require.Error(t, err)
+	assert.Equal(t, ErrorIncompatibleTighten, err.(*TaggedUrnError).Code)
+
+	_, err = urn.Tighten("ext", "*")
+	require.Error(t, err)
+	assert.Equal(t, ErrorIncompatibleTighten, err.(*TaggedUrnError).Code)
+
+	_, err = urn.Tighten("ext", "")
+	require.Error(t, err)
+	assert.Equal(t, ErrorEmptyTag, err.(*TaggedUrnError).Code)
+}
+
+func TestKindOf(t *testing.T) {
+	assert.Equal(t, KindUnspecified, KindOf("?"))
+	assert.Equal(t, KindMustNotHave, KindOf("!"))
+	assert.Equal(t, KindMustHaveAny, KindOf("*"))
+	assert.Equal(t, KindExact, KindOf("pdf"))
+	assert.Equal(t, "must-have-any", KindMustHaveAny.String())
+}
+
+func TestConstraintsToReach(t *testing.T) {
+	general, _ := NewTaggedUrnFromString("cap:op=generate;ext")
+	specific, _ := NewTaggedUrnFromString("cap:op=generate;ext=pdf;out=binary;debug=!;target=?")
+
+	constraints, err := general.ConstraintsToReach(specific)
+	require.NoError(t, err)
+	assert.Equal(t, []Constraint{
+		{Key: "debug", Kind: KindMustNotHave, Value: "!"},
+		{Key: "ext", Kind: KindExact, Value: "pdf"},
+		{Key: "out", Kind: KindExact, Value: "binary"},
+	}, constraints)
+
+	rendered := make([]string, len(constraints))
+	for i, c := range constraints {
+		rendered[i] = c.String()
+	}
+	assert.Equal(t, []string{"debug=!", "ext=pdf", "out=binary"}, rendered)
+
+	// Equivalent URNs need nothing
+	constraints, err = specific.ConstraintsToReach(specific)
+	require.NoError(t, err)
+	assert.Empty(t, constraints)
+}
+
+func TestConstraintsToReachErrors(t *testing.T) {
+	general, _ := NewTaggedUrnFromString("cap:op=generate")
+	conflicting, _ := NewTaggedUrnFromString("cap:op=extract;ext=pdf")
+
+	_, err := general.ConstraintsToReach(conflicting)
+	require.Error(t, err)
+	assert.Equal(t, ErrorNotSpecialization, err.(*TaggedUrnError).Code)
+
+	media, _ := NewTaggedUrnFromString("media:pdf")
+	_, err = general.ConstraintsToReach(media)
+	require.Error(t, err)
+	assert.Equal(t, ErrorPrefixMismatch, err.(*TaggedUrnError).Code)
+}
+
+func TestMatchesWithOptionsCaseInsensitiveValues(t *testing.T) {
+	upper, err := NewTaggedUrnFromString(`cap:key="Value";op=generate`)
+	require.NoError(t, err)
+	lower, err := NewTaggedUrnFromString(`cap:key=value`)
+	require.NoError(t, err)
+
+	// Default options stay case-sensitive, same as ConformsTo
+	matches, err := upper.MatchesWithOptions(lower, MatchOptions{})
+	require.NoError(t, err)
+	assert.False(t, matches)
+
+	matches, err = upper.MatchesWithOptions(lower, MatchOptions{CaseInsensitiveValues: true})
+	require.NoError(t, err)
+	assert.True(t, matches)
+
+	// Storage is unchanged
+	value, _ := upper.GetTag("key")
+	assert.Equal(t, "Value", value)
+
+	// Different values still fail
+	other, _ := NewTaggedUrnFromString(`cap:key="Other"`)
+	matches, err = upper.MatchesWithOptions(other, MatchOptions{CaseInsensitiveValues: true})
+	require.NoError(t, err)
+	assert.False(t, matches)
+
+	// Special values keep their semantics
+	forbidden, _ := NewTaggedUrnFromString(`cap:key=!`)
+	matches, err = upper.MatchesWithOptions(forbidden, MatchOptions{CaseInsensitiveValues: true})
+	require.NoError(t, err)
+	assert.False(t, matches)
+
+	_, err = upper.MatchesWithOptions(nil, MatchOptions{})
+	assert.Error(t, err)
+}
+
+func TestParseWithOptionsNormalizeUnicode(t *testing.T) {
+	composed := "cap:name=\"caf\u00e9\""
+	decomposed := "cap:name=\"cafe\u0301\""
+
+	// Default: byte-wise different values do not compare equal
+	a, err := NewTaggedUrnFromString(composed)
+	require.NoError(t, err)
+	b, err := NewTaggedUrnFromString(decomposed)
+	require.NoError(t, err)
+	assert.False(t, a.Equals(b))
+
+	// NFC normalization makes both forms equal
+	opts := ParseOptions{NormalizeUnicode: true}
+	a, err = ParseWithOptions(composed, opts)
+	require.NoError(t, err)
+	b, err = ParseWithOptions(decomposed, opts)
+	require.NoError(t, err)
+	assert.True(t, a.Equals(b))
+	assert.Equal(t, a.Hash(), b.Hash())
+
+	value, _ := b.GetTag("name")
+	assert.Equal(t, "caf\u00e9", value)
+}
+
+func TestParseWithOptionsZeroValueMatchesDefault(t *testing.T) {
+	input := `cap:op=generate;ext=PDF;name="Hello World";flag`
+	expected, err := NewTaggedUrnFromString(input)
+	require.NoError(t, err)
+	actual, err := ParseWithOptions(input, ParseOptions{})
+	require.NoError(t, err)
+	assert.True(t, expected.Equals(actual))
+}
+
+func TestAppendToAndWriteToMatchToString(t *testing.T) {
+	inputs := []string{
+		"cap:",
+		"cap:op=generate",
+		`cap:ext=pdf;name="Hello World";op=generate;flag;debug=!;opt=?`,
+		`myapp:path="a\"b\\c"`,
+	}
+	for _, input := range inputs {
+		urn, err := NewTaggedUrnFromString(input)
+		require.NoError(t, err)
+		expected := urn.ToString()
+
+		assert.Equal(t, expected, string(urn.AppendTo(nil)))
+
+		scratch := []byte("prefix|")
+		assert.Equal(t, "prefix|"+expected, string(urn.AppendTo(scratch)))
+
+		var buf bytes.Buffer
+		n, err := urn.WriteTo(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(expected)), n)
+		assert.Equal(t, expected, buf.String())
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestWriteToPropagatesErrors(t *testing.T) {
+	urn, err := NewTaggedUrnFromString("cap:op=generate;ext=pdf")
+	require.NoError(t, err)
+	_, err = urn.WriteTo(failingWriter{})
+	assert.Error(t, err)
+}
+
+func benchmarkUrn(b *testing.B) *TaggedUrn {
+	urn, err := NewTaggedUrnFromString(`cap:op=generate;ext=pdf;target=thumbnail;out=binary;name="Hello World";debug=!`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return urn
+}
+
+func BenchmarkToString(b *testing.B) {
+	urn := benchmarkUrn(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = urn.ToString()
+	}
+}
+
+func BenchmarkAppendTo(b *testing.B) {
+	urn := benchmarkUrn(b)
+	buf := make([]byte, 0, 256)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = urn.AppendTo(buf[:0])
+	}
+}
+
+func BenchmarkToStringBytes(b *testing.B) {
+	urn := benchmarkUrn(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = []byte(urn.ToString())
+	}
+}
+
+func BenchmarkCanonicalBytes(b *testing.B) {
+	urn := benchmarkUrn(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = urn.CanonicalBytes()
+	}
+}
+
+func BenchmarkWriteTo(b *testing.B) {
+	urn := benchmarkUrn(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := urn.WriteTo(io.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBuilderNew(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = NewTaggedUrnBuilder("cap").
+			Tag("op", "generate").
+			Tag("ext", "pdf").
+			Tag("target", "thumbnail").
+			Tag("out", "binary").
+			Tag("quality", "high").
+			Tag("name", "Hello World").
+			Build()
+	}
+}
+
+func BenchmarkBuilderReset(b *testing.B) {
+	builder := NewTaggedUrnBuilder("cap")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = builder.Reset("cap").
+			Tag("op", "generate").
+			Tag("ext", "pdf").
+			Tag("target", "thumbnail").
+			Tag("out", "binary").
+			Tag("quality", "high").
+			Tag("name", "Hello World").
+			Build()
+	}
+}
+
+func TestParseWithOptionsTrailingComment(t *testing.T) {
+	opts := ParseOptions{AllowTrailingComment: true}
+
+	urn, err := ParseWithOptions("cap:op=generate # main capability", opts)
+	require.NoError(t, err)
+	assert.Equal(t, "cap:op=generate", urn.ToString())
+
+	urn, err = ParseWithOptions("cap:op=generate;ext=pdf;#note", opts)
+	require.NoError(t, err)
+	assert.Equal(t, "cap:ext=pdf;op=generate", urn.ToString())
+
+	// A comment-only tag section leaves an empty URN
+	urn, err = ParseWithOptions("cap: # nothing yet", opts)
+	require.NoError(t, err)
+	assert.Equal(t, "cap:", urn.ToString())
+
+	// '#' inside a quoted value is preserved
+	urn, err = ParseWithOptions(`cap:tag="a#b";op=x # trailing`, opts)
+	require.NoError(t, err)
+	value, _ := urn.GetTag("tag")
+	assert.Equal(t, "a#b", value)
+	op, _ := urn.GetTag("op")
+	assert.Equal(t, "x", op)
+
+	// Escaped quotes do not end the quoted value early
+	urn, err = ParseWithOptions(`cap:tag="say \"#1\"" # comment`, opts)
+	require.NoError(t, err)
+	value, _ = urn.GetTag("tag")
+	assert.Equal(t, `say "#1"`, value)
+
+	// A '"' or '#' inside an unquoted regex is part of the regex
+	urn, err = ParseWithOptions(`cap:re=/a"b/;op=x # c`, opts)
+	require.NoError(t, err)
+	value, _ = urn.GetTag("re")
+	assert.Equal(t, `/a"b/`, value)
+	assert.Equal(t, KindRegex, urn.kindOf("re"))
+
+	urn, err = ParseWithOptions("cap:re=/a#b/", opts)
+	require.NoError(t, err)
+	value, _ = urn.GetTag("re")
+	assert.Equal(t, "/a#b/", value)
+
+	urn, err = ParseWithOptions("cap:re=/a#b/ # c", opts)
+	require.NoError(t, err)
+	value, _ = urn.GetTag("re")
+	assert.Equal(t, "/a#b/", value)
+
+	// Leading whitespace is still rejected
+	_, err = ParseWithOptions(" cap:op=generate # c", opts)
+	require.Error(t, err)
+	assert.Equal(t, ErrorWhitespaceInInput, err.(*TaggedUrnError).Code)
+}
+
+func TestTrailingCommentRejectedByDefault(t *testing.T) {
+	_, err := NewTaggedUrnFromString("cap:op=generate#comment")
+	require.Error(t, err)
+	assert.Equal(t, ErrorInvalidCharacter, err.(*TaggedUrnError).Code)
+
+	_, err = ParseWithOptions("cap:op=generate # comment", ParseOptions{})
+	assert.Error(t, err)
+}
+
+func TestParseWithOptionsDuplicateKeyPolicy(t *testing.T) {
+	input := "cap:ext=pdf;op=generate;ext=docx"
+
+	// Error (default) preserves strict rejection
+	_, err := ParseWithOptions(input, ParseOptions{})
+	require.Error(t, err)
+	assert.Equal(t, ErrorDuplicateKey, err.(*TaggedUrnError).Code)
+	_, err = ParseWithOptions(input, ParseOptions{DuplicateKeyPolicy: DuplicateKeyError})
+	require.Error(t, err)
+	assert.Equal(t, ErrorDuplicateKey, err.(*TaggedUrnError).Code)
+
+	// LastWins
+	urn, err := ParseWithOptions(input, ParseOptions{DuplicateKeyPolicy: DuplicateKeyLastWins})
+	require.NoError(t, err)
+	assert.Equal(t, "cap:ext=docx;op=generate", urn.ToString())
+
+	// FirstWins
+	urn, err = ParseWithOptions(input, ParseOptions{DuplicateKeyPolicy: DuplicateKeyFirstWins})
+	require.NoError(t, err)
+	assert.Equal(t, "cap:ext=pdf;op=generate", urn.ToString())
+
+	// Keys are compared after lowercasing, value-less duplicates included
+	urn, err = ParseWithOptions("cap:EXT=pdf;ext", ParseOptions{DuplicateKeyPolicy: DuplicateKeyLastWins})
+	require.NoError(t, err)
+	assert.Equal(t, "cap:ext", urn.ToString())
+
+	// Other validation still applies to duplicates
+	_, err = ParseWithOptions("cap:a=1;123=x;123=y", ParseOptions{DuplicateKeyPolicy: DuplicateKeyFirstWins})
+	require.Error(t, err)
+	assert.Equal(t, ErrorNumericKey, err.(*TaggedUrnError).Code)
+}
+
+func TestIsConcreteInstanceAndIsPattern(t *testing.T) {
+	cases := []struct {
+		input    string
+		concrete bool
+	}{
+		{"cap:op=generate;ext=pdf", true},
+		{"cap:", true},
+		{"cap:op=generate;ext", false},
+		{"cap:op=generate;debug=!", false},
+		{"cap:op=generate;target=?", false},
+		{`cap:name="*literal"`, true},
+	}
+	for _, tc := range cases {
+		urn, err := NewTaggedUrnFromString(tc.input)
+		require.NoError(t, err)
+		assert.Equal(t, tc.concrete, urn.IsConcreteInstance(), tc.input)
+		assert.Equal(t, !tc.concrete, urn.IsPattern(), tc.input)
+	}
+}
+
+func TestMatchesStrict(t *testing.T) {
+	pattern, _ := NewTaggedUrnFromString("cap:op=generate;ext")
+
+	instance, _ := NewTaggedUrnFromString("cap:op=generate;ext=pdf")
+	matches, err := instance.MatchesStrict(pattern)
+	require.NoError(t, err)
+	assert.True(t, matches)
+
+	// * in the instance is allowed (symmetric in the truth table)
+	wildcard, _ := NewTaggedUrnFromString("cap:op=generate;ext")
+	matches, err = wildcard.MatchesStrict(pattern)
+	require.NoError(t, err)
+	assert.True(t, matches)
+
+	mismatch, _ := NewTaggedUrnFromString("cap:op=extract;ext=pdf")
+	matches, err = mismatch.MatchesStrict(pattern)
+	require.NoError(t, err)
+	assert.False(t, matches)
+
+	// Pattern-only sentinels in the receiver are rejected
+	for _, input := range []string{"cap:op=generate;ext=pdf;debug=!", "cap:op=generate;ext=?"} {
+		misused, _ := NewTaggedUrnFromString(input)
+		_, err = misused.MatchesStrict(pattern)
+		require.Error(t, err, input)
+		assert.Equal(t, ErrorPatternUsedAsInstance, err.(*TaggedUrnError).Code)
+
+		// The permissive form still answers
+		_, err = misused.ConformsTo(pattern)
+		assert.NoError(t, err)
+	}
+
+	_, err = instance.MatchesStrict(nil)
+	assert.Error(t, err)
+}
+
+func TestNewTaggedUrnWithPrefix(t *testing.T) {
+	urn, err := NewTaggedUrnWithPrefix("cap", "cap:op=generate")
+	require.NoError(t, err)
+	assert.Equal(t, "cap:op=generate", urn.ToString())
+
+	// Case-insensitive on both sides
+	urn, err = NewTaggedUrnWithPrefix("CAP", "Cap:op=generate")
+	require.NoError(t, err)
+	assert.Equal(t, "cap", urn.GetPrefix())
+
+	_, err = NewTaggedUrnWithPrefix("cap", "media:pdf")
+	require.Error(t, err)
+	assert.Equal(t, ErrorPrefixMismatch, err.(*TaggedUrnError).Code)
+
+	// Parse errors win over prefix checks
+	_, err = NewTaggedUrnWithPrefix("cap", "cap:ext=")
+	require.Error(t, err)
+	assert.Equal(t, ErrorEmptyTag, err.(*TaggedUrnError).Code)
+}
+
+func TestSamePrefix(t *testing.T) {
+	a, _ := NewTaggedUrnFromString("cap:op=generate")
+	b, _ := NewTaggedUrnFromString("CAP:ext=pdf")
+	media, _ := NewTaggedUrnFromString("media:pdf")
+
+	prefix, err := SamePrefix([]*TaggedUrn{a, b})
+	require.NoError(t, err)
+	assert.Equal(t, "cap", prefix)
+
+	prefix, err = SamePrefix(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "", prefix)
+
+	_, err = SamePrefix([]*TaggedUrn{a, b, media})
+	require.Error(t, err)
+	assert.Equal(t, ErrorPrefixMismatch, err.(*TaggedUrnError).Code)
+	assert.Contains(t, err.Error(), "index 2")
+
+	_, err = SamePrefix([]*TaggedUrn{a, nil})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "index 1")
+}
+
+func TestMatcherChecksPrefixesUpFront(t *testing.T) {
+	matcher := &UrnMatcher{}
+	a, _ := NewTaggedUrnFromString("cap:op=generate")
+	media, _ := NewTaggedUrnFromString("media:pdf")
+	request, _ := NewTaggedUrnFromString("cap:op=generate")
+
+	_, err := matcher.FindBestMatch([]*TaggedUrn{a, media}, request)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "index 1")
+
+	_, err = matcher.FindAllMatches([]*TaggedUrn{a, nil}, request)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "index 1")
+
+	mediaRequest, _ := NewTaggedUrnFromString("media:")
+	_, err = matcher.FindBestMatchFunc([]*TaggedUrn{a}, mediaRequest, nil)
+	require.Error(t, err)
+	assert.Equal(t, ErrorPrefixMismatch, err.(*TaggedUrnError).Code)
+
+	// An empty candidate list still returns no match
+	best, err := matcher.FindBestMatch(nil, request)
+	require.NoError(t, err)
+	assert.Nil(t, best)
+}
+
+func TestFilterByKind(t *testing.T) {
+	urn, err := NewTaggedUrnFromString("cap:op=generate;ext=pdf;format;quality;debug=!;target=?")
+	require.NoError(t, err)
+
+	assert.Equal(t, "cap:ext=pdf;op=generate", urn.FilterByKind(KindExact).ToString())
+	assert.Equal(t, "cap:format;quality", urn.FilterByKind(KindMustHaveAny).ToString())
+	assert.Equal(t, "cap:debug=!", urn.FilterByKind(KindMustNotHave).ToString())
+	assert.Equal(t, "cap:target=?", urn.FilterByKind(KindUnspecified).ToString())
+
+	// Original is unchanged and prefix is kept
+	assert.Len(t, urn.AllTags(), 6)
+	empty, _ := NewTaggedUrnFromString("media:pdf")
+	assert.Equal(t, "media:", empty.FilterByKind(KindMustNotHave).ToString())
+}
+
+func TestParseWithOptionsEmptyValueAsWildcard(t *testing.T) {
+	opts := ParseOptions{EmptyValueAsWildcard: true}
+
+	urn, err := ParseWithOptions("cap:key=", opts)
+	require.NoError(t, err)
+	value, _ := urn.GetTag("key")
+	assert.Equal(t, "*", value)
+	assert.Equal(t, "cap:key", urn.ToString())
+
+	urn, err = ParseWithOptions("cap:a=;b=v", opts)
+	require.NoError(t, err)
+	assert.Equal(t, "cap:a;b=v", urn.ToString())
+
+	// Quoted empty values are still rejected
+	_, err = ParseWithOptions(`cap:a="";b=v`, opts)
+	require.Error(t, err)
+	assert.Equal(t, ErrorEmptyTag, err.(*TaggedUrnError).Code)
+
+	// Default stays an error
+	for _, input := range []string{"cap:key=", "cap:a=;b=v"} {
+		_, err = ParseWithOptions(input, ParseOptions{})
+		require.Error(t, err, input)
+		assert.Equal(t, ErrorEmptyTag, err.(*TaggedUrnError).Code)
+	}
+}
+
+func TestTagsString(t *testing.T) {
+	urn, err := NewTaggedUrnFromString(`cap:op=generate;ext=pdf;url="http://x";flag`)
+	require.NoError(t, err)
+	assert.Equal(t, `ext=pdf;flag;op=generate;url=http://x`, urn.TagsString())
+	assert.Equal(t, urn.ToString(), urn.GetPrefix()+":"+urn.TagsString())
+
+	// Recombine under a different prefix
+	other, err := NewTaggedUrnFromString("media:" + urn.TagsString())
+	require.NoError(t, err)
+	assert.Equal(t, urn.AllTags(), other.AllTags())
+
+	empty, _ := NewTaggedUrnFromString("cap:")
+	assert.Equal(t, "", empty.TagsString())
+}
+
+func TestTagsEqual(t *testing.T) {
+	capUrn, _ := NewTaggedUrnFromString("cap:op=generate;ext=pdf;debug=!")
+	testUrn, _ := NewTaggedUrnFromString("test:ext=pdf;debug=!;op=generate")
+
+	assert.False(t, capUrn.Equals(testUrn))
+	assert.True(t, capUrn.TagsEqual(testUrn))
+	assert.True(t, testUrn.TagsEqual(capUrn))
+
+	different, _ := NewTaggedUrnFromString("test:ext=pdf;op=generate")
+	assert.False(t, capUrn.TagsEqual(different))
+	changed, _ := NewTaggedUrnFromString("test:ext=docx;debug=!;op=generate")
+	assert.False(t, capUrn.TagsEqual(changed))
+	assert.False(t, capUrn.TagsEqual(nil))
+}
+
+func TestMapValues(t *testing.T) {
+	urn, err := NewTaggedUrnFromString(`cap:op=generate;token="s3cr3t";user=alice;debug`)
+	require.NoError(t, err)
+
+	redacted := urn.MapValues(func(key, value string) string {
+		if key == "token" {
+			return "redacted"
+		}
+		return value
+	})
+	assert.Equal(t, "cap:debug;op=generate;token=redacted;user=alice", redacted.ToString())
+
+	// Sentinels become constraints and round-trip
+	constrained := urn.MapValues(func(key, value string) string {
+		if key == "user" {
+			return "!"
+		}
+		return value
+	})
+	assert.Equal(t, `cap:debug;op=generate;token=s3cr3t;user=!`, constrained.ToString())
+	reparsed, err := NewTaggedUrnFromString(constrained.ToString())
+	require.NoError(t, err)
+	assert.True(t, reparsed.Equals(constrained))
+
+	// Empty result drops the tag
+	dropped := urn.MapValues(func(key, value string) string {
+		if key == "token" {
+			return ""
+		}
+		return value
+	})
+	_, exists := dropped.GetTag("token")
+	assert.False(t, exists)
+
+	// Original unchanged
+	token, _ := urn.GetTag("token")
+	assert.Equal(t, "s3cr3t", token)
+}
+
+func TestToStringWithOrder(t *testing.T) {
+	urn, err := NewTaggedUrnFromString(`cap:ext=pdf;op=generate;target=thumbnail;name="A B";flag`)
+	require.NoError(t, err)
+
+	assert.Equal(t, `cap:op=generate;target=thumbnail;ext=pdf;flag;name="A B"`, urn.ToStringWithOrder([]string{"op", "target"}))
+	// Unknown and repeated keys are skipped; order keys are case-insensitive
+	assert.Equal(t, `cap:op=generate;ext=pdf;flag;name="A B";target=thumbnail`, urn.ToStringWithOrder([]string{"OP", "missing", "op"}))
+	// Empty order equals canonical form
+	assert.Equal(t, urn.ToString(), urn.ToStringWithOrder(nil))
+
+	for _, order := range [][]string{{"op"}, {"target", "ext", "op"}, {"name", "flag"}} {
+		reparsed, err := NewTaggedUrnFromString(urn.ToStringWithOrder(order))
+		require.NoError(t, err)
+		assert.True(t, reparsed.Equals(urn), "order %v", order)
+		assert.Equal(t, urn.ToString(), reparsed.ToString())
+	}
+
+	empty, _ := NewTaggedUrnFromString("cap:")
+	assert.Equal(t, "cap:", empty.ToStringWithOrder([]string{"op"}))
+}
+
+func TestIsEmpty(t *testing.T) {
+	assert.True(t, Empty("cap").IsEmpty())
+	assert.True(t, Empty("media").IsEmpty())
+
+	parsed, _ := NewTaggedUrnFromString("cap:;")
+	assert.True(t, parsed.IsEmpty())
+
+	urn, _ := NewTaggedUrnFromString("cap:op=generate")
+	assert.False(t, urn.IsEmpty())
+	assert.True(t, urn.WithoutTag("op").IsEmpty())
+
+	wildcard, _ := NewTaggedUrnFromString("cap:ext=?")
+	assert.False(t, wildcard.IsEmpty())
+}
+
+func TestMatchesWithOptionsHierarchicalKeys(t *testing.T) {
+	opts := MatchOptions{HierarchicalKeys: map[string]bool{"path": true}}
+	pattern, _ := NewTaggedUrnFromString("cap:path=/a/b")
+
+	check := func(instance string, expected bool) {
+		t.Helper()
+		inst, err := NewTaggedUrnFromString(instance)
+		require.NoError(t, err)
+		matches, err := inst.MatchesWithOptions(pattern, opts)
+		require.NoError(t, err)
+		assert.Equal(t, expected, matches, instance)
+	}
+
+	check("cap:path=/a/b", true)
+	check("cap:path=/a/b/c", true)
+	check("cap:path=/a/b/c/d", true)
+	check("cap:path=/a/b/", true)
+	check("cap:path=/a/bc", false)
+	check("cap:path=/a", false)
+	check("cap:path=/x/a/b", false)
+	check("cap:path=a/b", false)
+	check("cap:", false)
+	check("cap:path", true)
+
+	// Trailing slash on the pattern is ignored
+	slashed, _ := NewTaggedUrnFromString("cap:path=/a/b/")
+	inst, _ := NewTaggedUrnFromString("cap:path=/a/b/c")
+	matches, err := inst.MatchesWithOptions(slashed, opts)
+	require.NoError(t, err)
+	assert.True(t, matches)
+
+	// Root covers every absolute path
+	root, _ := NewTaggedUrnFromString("cap:path=/")
+	matches, err = inst.MatchesWithOptions(root, opts)
+	require.NoError(t, err)
+	assert.True(t, matches)
+
+	// Case follows CaseInsensitiveValues
+	upper, _ := NewTaggedUrnFromString(`cap:path="/A/B/c"`)
+	matches, err = upper.MatchesWithOptions(pattern, opts)
+	require.NoError(t, err)
+	assert.False(t, matches)
+	opts.CaseInsensitiveValues = true
+	matches, err = upper.MatchesWithOptions(pattern, opts)
+	require.NoError(t, err)
+	assert.True(t, matches)
+
+	// Non-listed keys and default options keep exact matching
+	matches, err = inst.MatchesWithOptions(pattern, MatchOptions{})
+	require.NoError(t, err)
+	assert.False(t, matches)
+	other, _ := NewTaggedUrnFromString("cap:dir=/a/b/c")
+	dirPattern, _ := NewTaggedUrnFromString("cap:dir=/a/b")
+	matches, err = other.MatchesWithOptions(dirPattern, opts)
+	require.NoError(t, err)
+	assert.False(t, matches)
+}
+
+func TestSingle(t *testing.T) {
+	urn, err := Single("CAP", "Op", "generate")
+	require.NoError(t, err)
+	assert.Equal(t, "cap:op=generate", urn.ToString())
+
+	wildcard, err := Single("cap", "ext", "*")
+	require.NoError(t, err)
+	assert.Equal(t, "cap:ext", wildcard.ToString())
+
+	quoted, err := Single("cap", "name", "Hello World")
+	require.NoError(t, err)
+	assert.Equal(t, `cap:name="Hello World"`, quoted.ToString())
+
+	_, err = Single("", "op", "generate")
+	require.Error(t, err)
+	assert.Equal(t, ErrorEmptyPrefix, err.(*TaggedUrnError).Code)
+
+	_, err = Single("cap", "123", "x")
+	require.Error(t, err)
+	assert.Equal(t, ErrorNumericKey, err.(*TaggedUrnError).Code)
+
+	_, err = Single("cap", "bad key", "x")
+	require.Error(t, err)
+	assert.Equal(t, ErrorInvalidCharacter, err.(*TaggedUrnError).Code)
+
+	_, err = Single("cap", "op", "")
+	require.Error(t, err)
+	assert.Equal(t, ErrorEmptyTag, err.(*TaggedUrnError).Code)
+}
+
+func TestAllTagsSorted(t *testing.T) {
+	urn, err := NewTaggedUrnFromString("cap:target=thumbnail;op=generate;ext=pdf;debug=!")
+	require.NoError(t, err)
+
+	assert.Equal(t, [][2]string{
+		{"debug", "!"},
+		{"ext", "pdf"},
+		{"op", "generate"},
+		{"target", "thumbnail"},
+	}, urn.AllTagsSorted())
+
+	assert.Empty(t, Empty("cap").AllTagsSorted())
+}
+
+func TestToStringWithExplicitWildcard(t *testing.T) {
+	urn, err := NewTaggedUrnFromString("cap:op=generate;ext;debug=!;draft=?")
+	require.NoError(t, err)
+
+	assert.Equal(t, urn.ToString(), urn.ToStringWith(SerializeOptions{}))
+
+	explicit := urn.ToStringWith(SerializeOptions{ExplicitWildcard: true})
+	assert.Equal(t, "cap:debug=!;draft=?;ext=*;op=generate", explicit)
+
+	parsed, err := NewTaggedUrnFromString(explicit)
+	require.NoError(t, err)
+	assert.True(t, urn.Equals(parsed))
+	assert.Equal(t, "cap:debug=!;draft=?;ext;op=generate", parsed.ToString())
+}
+
+func TestMatchesExplain(t *testing.T) {
+	pattern, err := NewTaggedUrnFromString("cap:op=generate;ext=pdf;debug=!;target")
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		instance string
+		reason   string
+	}{
+		{"cap:op=generate;ext=pdf;target=thumb", ""},
+		{"cap:op=generate;ext=png;target=thumb", "tag 'ext': pattern requires 'pdf' but instance has 'png'"},
+		{"cap:op=generate;ext=pdf", "tag 'target': pattern requires it but instance lacks it"},
+		{"cap:op=generate;ext=pdf;target=thumb;debug=on", "tag 'debug': pattern forbids it but instance has 'on'"},
+		{"cap:op=generate;ext=!;target=thumb", "tag 'ext': instance forbids it but pattern requires 'pdf'"},
+		{"cap:op=extract;ext=png;target=thumb", "tag 'ext': pattern requires 'pdf' but instance has 'png'"},
+	} {
+		instance, err := NewTaggedUrnFromString(tc.instance)
+		require.NoError(t, err)
+		matched, reason, err := instance.MatchesExplain(pattern)
+		require.NoError(t, err)
+		assert.Equal(t, tc.reason == "", matched, tc.instance)
+		assert.Equal(t, tc.reason, reason, tc.instance)
+	}
+
+	instance, err := NewTaggedUrnFromString("cap:op=generate;ext=pdf;target=thumb")
+	require.NoError(t, err)
+	_, _, err = instance.MatchesExplain(nil)
+	assert.Error(t, err)
+}
+
+func TestUrnMatcherExplain(t *testing.T) {
+	matcher := &UrnMatcher{}
+	request, err := NewTaggedUrnFromString("cap:op=generate;ext=pdf")
+	require.NoError(t, err)
+
+	png, _ := NewTaggedUrnFromString("cap:op=generate;ext=png")
+	pdf, _ := NewTaggedUrnFromString("cap:op=generate;ext=pdf;quality=high")
+	media, _ := NewTaggedUrnFromString("media:ext=pdf")
+
+	attempts := matcher.Explain([]*TaggedUrn{png, pdf, media, nil}, request)
+	require.Len(t, attempts, 4)
+
+	assert.Same(t, png, attempts[0].Urn)
+	assert.False(t, attempts[0].Matched)
+	assert.Equal(t, "tag 'ext': pattern requires 'pdf' but instance has 'png'", attempts[0].Reason)
+
+	assert.True(t, attempts[1].Matched)
+	assert.Empty(t, attempts[1].Reason)
+
+	assert.False(t, attempts[2].Matched)
+	assert.Contains(t, attempts[2].Reason, "different prefixes")
+
+	assert.False(t, attempts[3].Matched)
+	assert.Equal(t, "candidate is nil", attempts[3].Reason)
+}
+
+func TestConditionalPatternValue(t *testing.T) {
+	pattern, err := NewTaggedUrnFromString("cap:op=generate;ext=~PDF")
+	require.NoError(t, err)
+	assert.Equal(t, "cap:ext=~pdf;op=generate", pattern.ToString())
+	assert.Equal(t, KindConditional, KindOf("~pdf"))
+	assert.Equal(t, 5, pattern.Specificity())
+	assert.True(t, pattern.IsPattern())
+
+	for _, tc := range []struct {
+		instance string
+		expected bool
+	}{
+		{"cap:op=generate", true},
+		{"cap:op=generate;ext=pdf", true},
+		{"cap:op=generate;ext=png", false},
+		{"cap:op=generate;ext=!", true},
+		{"cap:op=generate;ext", true},
+		{"cap:op=generate;ext=?", true},
+	} {
+		instance, err := NewTaggedUrnFromString(tc.instance)
+		require.NoError(t, err)
+		matches, err := instance.ConformsTo(pattern)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, matches, tc.instance)
+	}
+
+	png, err := NewTaggedUrnFromString("cap:op=generate;ext=png")
+	require.NoError(t, err)
+	_, reason, err := png.MatchesExplain(pattern)
+	require.NoError(t, err)
+	assert.Equal(t, "tag 'ext': pattern allows only 'pdf' when present but instance has 'png'", reason)
+
+	// '~' is only valid unquoted at the start of a value
+	_, err = NewTaggedUrnFromString("cap:ext=a~b")
+	assert.Error(t, err)
+	literal, err := NewTaggedUrnFromString(`cap:ext="a~b"`)
+	require.NoError(t, err)
+	assert.Equal(t, `cap:ext="a~b"`, literal.ToString())
+}
+
+func TestConditionalQuotedValueStaysLiteral(t *testing.T) {
+	pattern, err := NewTaggedUrnFromString(`cap:op=generate;ext="~pdf"`)
+	require.NoError(t, err)
+	assert.Equal(t, `cap:ext="~pdf";op=generate`, pattern.ToString())
+	assert.True(t, pattern.IsConcreteInstance())
+	assert.Equal(t, 6, pattern.Specificity())
+
+	for _, tc := range []struct {
+		instance string
+		expected bool
+	}{
+		{"cap:op=generate", false},
+		{"cap:op=generate;ext=pdf", false},
+		{"cap:op=generate;ext=!", false},
+		{`cap:op=generate;ext="~pdf"`, true},
+	} {
+		instance, err := NewTaggedUrnFromString(tc.instance)
+		require.NoError(t, err)
+		matches, err := instance.ConformsTo(pattern)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, matches, tc.instance)
+	}
+
+	pdf, err := NewTaggedUrnFromString("cap:op=generate;ext=pdf")
+	require.NoError(t, err)
+	_, reason, err := pdf.MatchesExplain(pattern)
+	require.NoError(t, err)
+	assert.Equal(t, "tag 'ext': pattern requires '~pdf' but instance has 'pdf'", reason)
+}
+
+func TestParseOptionsExtraChars(t *testing.T) {
+	_, err := NewTaggedUrnFromString("cap:email=user@host")
+	require.Error(t, err)
+
+	opts := ParseOptions{ExtraValueChars: []rune{'@', '+'}}
+	urn, err := ParseWithOptions("cap:email=user+tag@host;op=send", opts)
+	require.NoError(t, err)
+	assert.True(t, urn.HasTag("email", "user+tag@host"))
+	assert.Equal(t, KindExact, KindOf("user+tag@host"))
+
+	// The canonical form quotes the '@' so it parses without options
+	assert.Equal(t, `cap:email="user+tag@host";op=send`, urn.ToString())
+	reparsed, err := NewTaggedUrnFromString(urn.ToString())
+	require.NoError(t, err)
+	assert.True(t, urn.Equals(reparsed))
+
+	serialized := urn.ToStringWith(SerializeOptions{ExtraValueChars: opts.ExtraValueChars})
+	assert.Equal(t, "cap:email=user+tag@host;op=send", serialized)
+	reparsed, err = ParseWithOptions(serialized, opts)
+	require.NoError(t, err)
+	assert.True(t, urn.Equals(reparsed))
+
+	// Characters other than '@' and '~' are quoted exactly as before
+	plain := NewTaggedUrnFromTags("cap", map[string]string{"x": "a+b", "y": "a,b", "z": "A b"})
+	assert.Equal(t, `cap:x=a+b;y=a,b;z="A b"`, plain.ToString())
+
+	_, err = ParseWithOptions("cap:a=b", ParseOptions{ExtraValueChars: []rune{';'}})
+	assert.Error(t, err)
+
+	// Keys are never quoted, so only default key characters may be re-allowed
+	_, err = ParseWithOptions("cap:x+y=1", ParseOptions{ExtraKeyChars: []rune{'+'}})
+	require.Error(t, err)
+	assert.Equal(t, ErrorInvalidFormat, err.(*TaggedUrnError).Code)
+}
+
+func TestFingerprint(t *testing.T) {
+	a, err := NewTaggedUrnFromString("cap:op=generate;ext=pdf;timestamp=1700000000;request_id=abc")
+	require.NoError(t, err)
+	b, err := NewTaggedUrnFromString("cap:op=generate;ext=pdf;timestamp=1700000042;request_id=xyz")
+	require.NoError(t, err)
+	plain, err := NewTaggedUrnFromString("cap:op=generate;ext=pdf")
+	require.NoError(t, err)
+
+	assert.NotEqual(t, a.Hash(), b.Hash())
+	assert.Equal(t, a.Fingerprint("timestamp", "request_id"), b.Fingerprint("TIMESTAMP", "request_id"))
+	assert.Equal(t, plain.Hash(), a.Fingerprint("timestamp", "request_id"))
+	assert.NotEqual(t, a.Fingerprint("timestamp"), b.Fingerprint("timestamp"))
+	assert.Equal(t, a.Hash(), a.Fingerprint())
+	assert.Equal(t, plain.Hash(), plain.Fingerprint("missing"))
+}
+
+func TestHasPrefix(t *testing.T) {
+	urn, err := NewTaggedUrnFromString("CaP:op=generate")
+	require.NoError(t, err)
+
+	assert.True(t, urn.HasPrefix("cap"))
+	assert.True(t, urn.HasPrefix("CAP"))
+	assert.True(t, urn.HasPrefix("Cap"))
+	assert.False(t, urn.HasPrefix("media"))
+	assert.False(t, urn.HasPrefix(""))
+
+	assert.Equal(t, "myapp", NormalizePrefix("MyApp"))
+	assert.Equal(t, NormalizePrefix("MyApp"), Empty("MYAPP").GetPrefix())
+}
+
+func TestPreserveWildcardSyntax(t *testing.T) {
+	opts := ParseOptions{PreserveWildcardSyntax: true}
+	input := "cap:draft;ext=*;op=generate"
+
+	preserved, err := ParseWithOptions(input, opts)
+	require.NoError(t, err)
+	assert.Equal(t, input, preserved.ToString())
+	assert.Equal(t, "draft;ext=*;op=generate", preserved.TagsString())
+	assert.Equal(t, "cap:op=generate;ext=*;draft", preserved.ToStringWithOrder([]string{"op", "ext"}))
+	var buf bytes.Buffer
+	_, err = preserved.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, input, buf.String())
+
+	collapsed, err := NewTaggedUrnFromString(input)
+	require.NoError(t, err)
+	assert.Equal(t, "cap:draft;ext;op=generate", collapsed.ToString())
+
+	// The syntax is presentation only
+	assert.True(t, preserved.Equals(collapsed))
+	assert.Equal(t, collapsed.Hash(), preserved.Hash())
+	matches, err := collapsed.ConformsTo(preserved)
+	require.NoError(t, err)
+	assert.True(t, matches)
+
+	// Later duplicates decide the syntax; derived URNs fall back to shorthand
+	last, err := ParseWithOptions("cap:ext=*;ext", ParseOptions{PreserveWildcardSyntax: true, DuplicateKeyPolicy: DuplicateKeyLastWins})
+	require.NoError(t, err)
+	assert.Equal(t, "cap:ext", last.ToString())
+	assert.Equal(t, "cap:draft;ext;op=generate;x=1", preserved.WithTag("x", "1").ToString())
+}
+
+func TestValidateInstance(t *testing.T) {
+	urn, err := NewTaggedUrnFromString("cap:op=generate;ext;debug=!")
+	require.NoError(t, err)
+	assert.NoError(t, urn.ValidateInstance())
+
+	unspecified := urn.WithTag("quality", "?")
+	err = unspecified.ValidateInstance()
+	require.Error(t, err)
+	assert.Equal(t, ErrorPatternUsedAsInstance, err.(*TaggedUrnError).Code)
+	assert.Contains(t, err.Error(), "quality")
+}
+
+func TestValidatePattern(t *testing.T) {
+	pattern, err := NewTaggedUrnFromString("cap:op=generate;ext;debug=!;quality=?")
+	require.NoError(t, err)
+	assert.NoError(t, pattern.ValidatePattern())
+
+	invalid, err := NewTaggedUrnFromString("cap:name=/(/")
+	require.NoError(t, err)
+	err = invalid.ValidatePattern()
+	require.Error(t, err)
+	assert.Equal(t, ErrorInvalidRegex, err.(*TaggedUrnError).Code)
+
+	err = pattern.WithTag("color", "@validate-missing").ValidatePattern()
+	require.Error(t, err)
+	assert.Equal(t, ErrorUnknownEnum, err.(*TaggedUrnError).Code)
+
+	RegisterEnum("validate-colors", []string{"red"})
+	assert.NoError(t, pattern.WithTag("color", "@validate-colors").ValidatePattern())
+}
+
+func TestMutuallyExclusive(t *testing.T) {
+	RegisterEnum("exclusive-rgb", []string{"red", "green", "blue"})
+
+	for _, tc := range []struct {
+		a, b      string
+		exclusive bool
+	}{
+		{"cap:ext=pdf", "cap:op=generate", false},
+		{"cap:ext=pdf", "cap:ext=pdf", false},
+		{"cap:ext=pdf", "cap:ext=png", true},
+		{"cap:ext=!", "cap:ext", true},
+		{"cap:ext=!", "cap:ext=pdf", true},
+		{"cap:ext=!", "cap:ext=!", false},
+		{"cap:ext=!", "cap:ext=?", false},
+		{"cap:ext=!", "cap:ext=~pdf", false},
+		{"cap:ext", "cap:ext=pdf", false},
+		{"cap:ext", "cap:ext", false},
+		{"cap:ext=~pdf", "cap:ext=png", true},
+		{"cap:ext=~pdf", "cap:ext=~png", false},
+		{"cap:color=@exclusive-rgb", "cap:color=red", false},
+		{"cap:color=@exclusive-rgb", "cap:color=pink", true},
+		{"cap:color=@exclusive-rgb", "cap:color=!", true},
+		{"cap:color=@exclusive-rgb", `cap:color="@exclusive-rgb"`, true},
+		{`cap:color="@exclusive-rgb"`, `cap:color="@exclusive-rgb"`, false},
+		{`cap:name=/^img_\d+$/`, "cap:name=img_1", false},
+		{`cap:name=/^img_\d+$/`, "cap:name=doc_1", true},
+		{`cap:name=/^img_\d+$/`, "cap:name", false},
+		{`cap:name=/^img_\d+$/`, `cap:name=/^img_\d+$/`, false},
+		{"cap:name=/^a/;ext=pdf", "cap:name=/b$/;ext=png", true},
+		{"cap:name=/^a/", `cap:name="/b$/"`, true},
+		{"cap:op=generate;ext=pdf", "cap:op=generate;ext=!", true},
+	} {
+		a, err := NewTaggedUrnFromString(tc.a)
+		require.NoError(t, err)
+		b, err := NewTaggedUrnFromString(tc.b)
+		require.NoError(t, err)
+
+		exclusive, err := a.MutuallyExclusive(b)
+		require.NoError(t, err)
+		assert.Equal(t, tc.exclusive, exclusive, "%s vs %s", tc.a, tc.b)
+
+		compatible, err := b.IsCompatibleWith(a)
+		require.NoError(t, err)
+		assert.Equal(t, !tc.exclusive, compatible, "%s vs %s", tc.b, tc.a)
+	}
+
+	// Two different regexes might share a value: neither compatible nor exclusive
+	for _, pair := range [][2]string{
+		{`cap:name=/^img_\d+$/`, `cap:name=/^img_.*$/`},
+		{"cap:name=/^a/", "cap:name=/b$/"},
+	} {
+		a, err := NewTaggedUrnFromString(pair[0])
+		require.NoError(t, err)
+		b, err := NewTaggedUrnFromString(pair[1])
+		require.NoError(t, err)
+		exclusive, err := a.MutuallyExclusive(b)
+		require.NoError(t, err)
+		assert.False(t, exclusive, "%s vs %s", pair[0], pair[1])
+		compatible, err := a.IsCompatibleWith(b)
+		require.NoError(t, err)
+		assert.False(t, compatible, "%s vs %s", pair[0], pair[1])
+	}
+
+	a, _ := NewTaggedUrnFromString("cap:ext=pdf")
+	media, _ := NewTaggedUrnFromString("media:ext=pdf")
+	_, err := a.MutuallyExclusive(media)
+	require.Error(t, err)
+	assert.Equal(t, ErrorPrefixMismatch, err.(*TaggedUrnError).Code)
+	_, err = a.MutuallyExclusive(nil)
+	assert.Error(t, err)
+}
+
+func TestFindMatchesByTier(t *testing.T) {
+	matcher := &UrnMatcher{}
+	request, err := NewTaggedUrnFromString("cap:op=generate")
+	require.NoError(t, err)
+
+	parse := func(s string) *TaggedUrn {
+		urn, err := NewTaggedUrnFromString(s)
+		require.NoError(t, err)
+		return urn
+	}
+	urns := []*TaggedUrn{
+		parse("cap:op=generate"),
+		parse("cap:op=generate;ext"),
+		parse("cap:op=generate;ext=pdf"),
+		parse("cap:op=generate;b;a"),
+		parse("cap:op=generate;target=!;draft=!;debug=!"),
+		parse("cap:op=extract;ext=pdf"),
+		parse("cap:op=generate;ext=png"),
+	}
+
+	tiers, err := matcher.FindMatchesByTier(urns, request)
+	require.NoError(t, err)
+
+	var got [][]string
+	for _, tier := range tiers {
+		var names []string
+		for _, urn := range tier {
+			names = append(names, urn.ToString())
+		}
+		got = append(got, names)
+	}
+	assert.Equal(t, [][]string{
+		{"cap:a;b;op=generate"},
+		{"cap:ext=pdf;op=generate", "cap:ext=png;op=generate", "cap:debug=!;draft=!;op=generate;target=!"},
+		{"cap:ext;op=generate"},
+		{"cap:op=generate"},
+	}, got)
+
+	none, err := matcher.FindMatchesByTier(urns, parse("cap:op=convert"))
+	require.NoError(t, err)
+	assert.Nil(t, none)
+
+	_, err = matcher.FindMatchesByTier(urns, parse("media:op=generate"))
+	assert.Error(t, err)
+}
+
+func TestMatchesWithOptionsMissingInstanceTagIsWildcard(t *testing.T) {
+	instance, err := NewTaggedUrnFromString("cap:op=generate")
+	require.NoError(t, err)
+	pattern, err := NewTaggedUrnFromString("cap:ext=pdf")
+	require.NoError(t, err)
+
+	matches, err := instance.MatchesWithOptions(pattern, MatchOptions{})
+	require.NoError(t, err)
+	assert.False(t, matches)
+
+	legacy := MatchOptions{MissingInstanceTagIsWildcard: true}
+	matches, err = instance.MatchesWithOptions(pattern, legacy)
+	require.NoError(t, err)
+	assert.True(t, matches)
+
+	// Present tags are still compared
+	png, err := NewTaggedUrnFromString("cap:op=generate;ext=png")
+	require.NoError(t, err)
+	matches, err = png.MatchesWithOptions(pattern, legacy)
+	require.NoError(t, err)
+	assert.False(t, matches)
+
+	present, err := NewTaggedUrnFromString("cap:ext")
+	require.NoError(t, err)
+	matches, err = instance.MatchesWithOptions(present, legacy)
+	require.NoError(t, err)
+	assert.True(t, matches)
+}
+
+func TestBoolTag(t *testing.T) {
+	urn, err := NewTaggedUrnFromString("cap:debug=true;draft=0;verbose=1;quiet=false;color;trace=!;mode=fast")
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		key   string
+		value bool
+		set   bool
+	}{
+		{"debug", true, true},
+		{"DEBUG", true, true},
+		{"verbose", true, true},
+		{"draft", false, true},
+		{"quiet", false, true},
+		{"color", true, false},
+		{"trace", false, false},
+		{"mode", false, false},
+		{"missing", false, false},
+	} {
+		value, set := urn.BoolTag(tc.key)
+		assert.Equal(t, tc.value, value, tc.key)
+		assert.Equal(t, tc.set, set, tc.key)
+	}
+}
+
+func TestBuilderFlag(t *testing.T) {
+	urn, err := NewTaggedUrnBuilder("cap").Tag("op", "generate").Flag("Debug", true).Flag("draft", false).Build()
+	require.NoError(t, err)
+	assert.Equal(t, "cap:debug=true;draft=false;op=generate", urn.ToString())
+
+	value, set := urn.BoolTag("draft")
+	assert.False(t, value)
+	assert.True(t, set)
+}
+
+func TestDistinguish(t *testing.T) {
+	parse := func(s string) *TaggedUrn {
+		urn, err := NewTaggedUrnFromString(s)
+		require.NoError(t, err)
+		return urn
+	}
+	target := parse("cap:op=generate;ext=pdf;quality=high;target=thumbnail")
+	others := []*TaggedUrn{
+		parse("cap:op=generate;ext=png;quality=high;target=thumbnail"),
+		parse("cap:op=generate;ext=pdf;quality=low;target=thumbnail"),
+		parse("cap:op=extract;ext=pdf;quality=high;target=thumbnail"),
+		parse("media:op=generate;ext=pdf;quality=high;target=thumbnail"),
+		nil,
+	}
+
+	pattern, err := target.Distinguish(others)
+	require.NoError(t, err)
+	assert.Equal(t, "cap:ext=pdf;op=generate;quality=high", pattern.ToString())
+
+	matches, err := target.ConformsTo(pattern)
+	require.NoError(t, err)
+	assert.True(t, matches)
+	for _, other := range others[:3] {
+		matches, err := other.ConformsTo(pattern)
+		require.NoError(t, err)
+		assert.False(t, matches, other.ToString())
+	}
+
+	// The greedy choice prefers the tag that excludes the most candidates
+	pattern, err = target.Distinguish(others[:1])
+	require.NoError(t, err)
+	assert.Equal(t, "cap:ext=pdf", pattern.ToString())
+
+	pattern, err = target.Distinguish(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "cap:", pattern.ToString())
+}
+
+func TestDistinguishImpossible(t *testing.T) {
+	target, err := NewTaggedUrnFromString("cap:op=generate;ext=pdf")
+	require.NoError(t, err)
+	equal, err := NewTaggedUrnFromString("cap:ext=pdf;op=generate")
+	require.NoError(t, err)
+	superset, err := NewTaggedUrnFromString("cap:op=generate;ext=pdf;quality=high")
+	require.NoError(t, err)
+
+	_, err = target.Distinguish([]*TaggedUrn{equal})
+	require.Error(t, err)
+	assert.Equal(t, ErrorNotDistinguishable, err.(*TaggedUrnError).Code)
+
+	// Every tag of target is also in superset, so superset always conforms
+	_, err = target.Distinguish([]*TaggedUrn{superset})
+	require.Error(t, err)
+	assert.Equal(t, ErrorNotDistinguishable, err.(*TaggedUrnError).Code)
+}
+
+func TestParseOptionsExpandReferences(t *testing.T) {
+	opts := ParseOptions{ExpandReferences: true}
+
+	urn, err := ParseWithOptions("cap:src=pdf;dst=$SRC;op=convert", opts)
+	require.NoError(t, err)
+	assert.Equal(t, "cap:dst=pdf;op=convert;src=pdf", urn.ToString())
+
+	// Quoted values stay literal
+	urn, err = ParseWithOptions(`cap:src=pdf;dst="$src"`, opts)
+	require.NoError(t, err)
+	assert.True(t, urn.HasTag("dst", "$src"))
+
+	for _, input := range []string{
+		"cap:dst=$src;src=pdf", // forward reference
+		"cap:a=$a",             // self reference
+		"cap:a=$missing",
+		"cap:a=$",
+	} {
+		_, err = ParseWithOptions(input, opts)
+		require.Error(t, err, input)
+		assert.Equal(t, ErrorUndefinedReference, err.(*TaggedUrnError).Code, input)
+	}
+
+	// Without the option '$' is still invalid
+	_, err = NewTaggedUrnFromString("cap:src=pdf;dst=$src")
+	require.Error(t, err)
+	assert.Equal(t, ErrorInvalidCharacter, err.(*TaggedUrnError).Code)
+}
+
+func TestGeneralityOver(t *testing.T) {
+	universe := []string{"op", "ext", "quality", "target"}
+
+	for _, tc := range []struct {
+		urn        string
+		generality int
+	}{
+		{"cap:", 4},
+		{"cap:op=generate", 3},
+		{"cap:op=generate;ext=?", 3},
+		{"cap:op=generate;ext", 2},
+		{"cap:op=generate;ext=!;quality=high", 1},
+		{"cap:op=generate;ext=pdf;quality=high;target=thumb", 0},
+		{"cap:op=generate;unrelated=x", 3},
+	} {
+		urn, err := NewTaggedUrnFromString(tc.urn)
+		require.NoError(t, err)
+		assert.Equal(t, tc.generality, urn.GeneralityOver(universe), tc.urn)
+	}
+
+	urn, err := NewTaggedUrnFromString("cap:op=generate")
+	require.NoError(t, err)
+	assert.Equal(t, 1, urn.GeneralityOver([]string{"OP", "ext", "EXT"}))
+	assert.Equal(t, 0, urn.GeneralityOver(nil))
+}
+
+func TestToInstance(t *testing.T) {
+	pattern, err := NewTaggedUrnFromString("cap:op=generate;ext=*;debug=!")
+	require.NoError(t, err)
+
+	instance := pattern.ToInstance()
+	assert.Equal(t, "cap:op=generate", instance.ToString())
+	assert.True(t, instance.IsConcreteInstance())
+	assert.Equal(t, "cap:debug=!;ext;op=generate", pattern.ToString())
+
+	mixed, err := NewTaggedUrnFromString(`cap:op=generate;quality=?;color=@rgb;fit=~slim;name=/^a+$/`)
+	require.NoError(t, err)
+	assert.Equal(t, "cap:op=generate", mixed.ToInstance().ToString())
+}
+
+func TestCanonicalBytes(t *testing.T) {
+	urn, err := NewTaggedUrnFromString(`cap:op=generate;name="Hello World";ext`)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(urn.ToString()), urn.CanonicalBytes())
+
+	// Independent slices: mutating one result does not affect the next
+	first := urn.CanonicalBytes()
+	first[0] = 'X'
+	assert.Equal(t, urn.ToString(), string(urn.CanonicalBytes()))
+
+	preserved, err := ParseWithOptions("cap:ext=*", ParseOptions{PreserveWildcardSyntax: true})
+	require.NoError(t, err)
+	assert.Equal(t, "cap:ext=*", preserved.ToString())
+	assert.Equal(t, "cap:ext", string(preserved.CanonicalBytes()))
+}
+
+func TestSpecificityWithScores(t *testing.T) {
+	wildcard, err := NewTaggedUrnFromString("cap:op=generate;ext")
+	require.NoError(t, err)
+	forbidding, err := NewTaggedUrnFromString("cap:op=generate;debug=!")
+	require.NoError(t, err)
+
+	for _, urn := range []*TaggedUrn{wildcard, forbidding} {
+		assert.Equal(t, urn.Specificity(), urn.SpecificityWithScores(DefaultKindScores))
+	}
+
+	swapped := KindScores{Exact: 3, MustHaveAny: 1, MustNot: 2}
+	assert.Equal(t, 4, wildcard.SpecificityWithScores(swapped))
+	assert.Equal(t, 5, forbidding.SpecificityWithScores(swapped))
+
+	matcher := &UrnMatcher{}
+	request, err := NewTaggedUrnFromString("cap:op=generate")
+	require.NoError(t, err)
+	urns := []*TaggedUrn{forbidding, wildcard}
+
+	best, err := matcher.FindBestMatch(urns, request)
+	require.NoError(t, err)
+	assert.Same(t, wildcard, best)
+
+	best, err = matcher.FindBestMatchFunc(urns, request, func(u *TaggedUrn) int {
+		return u.SpecificityWithScores(swapped)
+	})
+	require.NoError(t, err)
+	assert.Same(t, forbidding, best)
+}
+
+func TestUnsafeValueKeys(t *testing.T) {
+	urn, err := NewTaggedUrnFromString(`cap:title="Hello World";op=generate;path="a;b";mode="Fast";ext`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mode", "path", "title"}, urn.UnsafeValueKeys())
+
+	safe, err := NewTaggedUrnFromString("cap:op=generate;ext=pdf;debug=!")
+	require.NoError(t, err)
+	assert.Empty(t, safe.UnsafeValueKeys())
+}
+
+func TestMatchesQuorum(t *testing.T) {
+	pattern, err := NewTaggedUrnFromString("cap:op=generate")
+	require.NoError(t, err)
+	flags := []string{"fast", "cached", "Signed"}
+
+	two, err := NewTaggedUrnFromString("cap:op=generate;fast=true;signed")
+	require.NoError(t, err)
+	ok, err := pattern.MatchesQuorum(two, flags, 2)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = pattern.MatchesQuorum(two, flags, 3)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// Forbidden and unspecified values do not count as present
+	forbidden, err := NewTaggedUrnFromString("cap:op=generate;fast=true;cached=!;signed=?")
+	require.NoError(t, err)
+	ok, err = pattern.MatchesQuorum(forbidden, flags, 2)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// Duplicate keys count once
+	ok, err = pattern.MatchesQuorum(two, []string{"fast", "fast"}, 2)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// The per-tag constraints still apply
+	other, err := NewTaggedUrnFromString("cap:op=extract;fast;cached;signed")
+	require.NoError(t, err)
+	ok, err = pattern.MatchesQuorum(other, flags, 1)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = pattern.MatchesQuorum(two, flags, -1)
+	require.Error(t, err)
+	assert.Equal(t, ErrorInvalidFormat, err.(*TaggedUrnError).Code)
+
+	_, err = pattern.MatchesQuorum(nil, flags, 1)
+	require.Error(t, err)
+}
+
+func TestPretty(t *testing.T) {
+	urn, err := NewTaggedUrnFromString(`cap:op=generate;ext;title="Hello World"`)
+	require.NoError(t, err)
+	assert.Equal(t, "cap:\n  ext   = *\n  op    = generate\n  title = \"Hello World\"", urn.Pretty())
+	assert.Equal(t, `cap:ext;op=generate;title="Hello World"`, urn.ToString())
+
+	assert.Equal(t, "cap:", Empty("cap").Pretty())
+}
+
+func TestToStringMultiline(t *testing.T) {
+	urn, err := NewTaggedUrnFromString("cap:op=generate;ext;note=\"line one\nline two\";name=/^a\"b$/")
+	require.NoError(t, err)
+
+	multiline := urn.ToStringMultiline()
+	assert.True(t, strings.HasPrefix(multiline, "cap:\next;\nname="))
+	assert.True(t, strings.HasSuffix(multiline, "\nop=generate;\n"))
+
+	parsed, err := ParseMultiline(multiline)
+	require.NoError(t, err)
+	assert.True(t, urn.Equals(parsed))
+	assert.Equal(t, urn.ToString(), parsed.ToString())
+
+	crlf, err := ParseMultiline(strings.ReplaceAll("cap:\next=pdf;\nop=generate;\n", "\n", "\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "cap:ext=pdf;op=generate", crlf.ToString())
+
+	single, err := ParseMultiline(urn.ToString())
+	require.NoError(t, err)
+	assert.True(t, urn.Equals(single))
+
+	assert.Equal(t, "cap:\n", Empty("cap").ToStringMultiline())
+	empty, err := ParseMultiline("cap:\n")
+	require.NoError(t, err)
+	assert.True(t, empty.IsEmpty())
+}
+
+func TestStrictKeyChars(t *testing.T) {
+	urn, err := ParseWithOptions("cap:a/b=x", ParseOptions{})
+	require.NoError(t, err)
+	assert.True(t, urn.HasTag("a/b", "x"))
+
+	for _, s := range []string{"cap:a/b=x", "cap:a.b=x", "cap:op=x;a:b"} {
+		_, err = ParseWithOptions(s, ParseOptions{StrictKeyChars: true})
+		require.Error(t, err, s)
+		assert.Equal(t, ErrorInvalidCharacter, err.(*TaggedUrnError).Code, s)
+	}
+
+	// Values are unaffected and extra key characters still apply
+	urn, err = ParseWithOptions("cap:my_key-2=/a/b.c;a.b", ParseOptions{StrictKeyChars: true, ExtraKeyChars: []rune{'.'}})
+	require.NoError(t, err)
+	assert.True(t, urn.HasTag("my_key-2", "/a/b.c"))
+	assert.True(t, urn.HasTag("a.b", "*"))
+}
+
+func TestSortKeyMatchesCompare(t *testing.T) {
+	var urns []*TaggedUrn
+	for _, s := range []string{
+		"cap:op=generate;ext=pdf",
+		"cap:op=generate",
+		"ca-x:op=a",
+		"ca:op=z",
+		"ca.b:",
+		"cap:",
+		"cap:ext;op=generate",
+		"cap:ext=!;op=generate",
+		`cap:title="Zeta"`,
+		"media:type=image",
+	} {
+		urn, err := NewTaggedUrnFromString(s)
+		require.NoError(t, err)
+		urns = append(urns, urn)
+	}
+
+	byCompare := append([]*TaggedUrn(nil), urns...)
+	sort.Slice(byCompare, func(i, j int) bool { return byCompare[i].Compare(byCompare[j]) < 0 })
+	bySortKey := append([]*TaggedUrn(nil), urns...)
+	sort.Slice(bySortKey, func(i, j int) bool { return bySortKey[i].SortKey() < bySortKey[j].SortKey() })
+
+	for i := range urns {
+		assert.Equal(t, byCompare[i].ToString(), bySortKey[i].ToString())
+	}
+
+	a, _ := NewTaggedUrnFromString("cap:op=generate;ext=pdf")
+	b, _ := NewTaggedUrnFromString("CAP:ext=pdf;op=generate")
+	assert.Equal(t, a.SortKey(), b.SortKey())
+}
+
+func TestBuilderWithOptions(t *testing.T) {
+	// Without options the builder accepts any key
+	urn, err := NewTaggedUrnBuilder("cap").Tag("a/b", "x").Build()
+	require.NoError(t, err)
+	assert.True(t, urn.HasTag("a/b", "x"))
+
+	_, err = NewTaggedUrnBuilder("cap").Tag("a/b", "x").WithOptions(ParseOptions{StrictKeyChars: true}).Build()
+	require.Error(t, err)
+	assert.Equal(t, ErrorInvalidCharacter, err.(*TaggedUrnError).Code)
+
+	_, err = NewTaggedUrnBuilder("cap").Tag("123", "x").WithOptions(ParseOptions{}).Build()
+	require.Error(t, err)
+	assert.Equal(t, ErrorNumericKey, err.(*TaggedUrnError).Code)
+
+	// A built "/.../" value is a literal, so it is never compiled
+	urn, err = NewTaggedUrnBuilder("cap").Tag("name", "/[/").WithOptions(ParseOptions{}).Build()
+	require.NoError(t, err)
+	assert.NoError(t, urn.ValidatePattern())
+
+	// Values keep their case and a built "$key" stays literal
+	urn, err = NewTaggedUrnBuilder("cap").
+		WithOptions(ParseOptions{ExpandReferences: true, NormalizeUnicode: true}).
+		Tag("Title", "Caf\u0065\u0301").
+		Tag("dst", "$title").
+		Build()
+	require.NoError(t, err)
+	assert.True(t, urn.HasTag("title", "Caf\u00e9"))
+	assert.True(t, urn.HasTag("dst", "$title"))
+
+	urn, err = NewTaggedUrnBuilder("cap").
+		WithOptions(ParseOptions{StrictKeyChars: true, ExtraKeyChars: []rune{'.'}}).
+		Tag("a.b", "me@example.com").
+		Build()
+	require.NoError(t, err)
+	assert.True(t, urn.HasTag("a.b", "me@example.com"))
+
+	// Built values are not reparsed: ~v keeps its meaning, /re/ stays literal
+	urn, err = NewTaggedUrnBuilder("cap").
+		WithOptions(ParseOptions{}).
+		Tag("lang", "~en").
+		Tag("name", "/^A/").
+		Build()
+	require.NoError(t, err)
+	assert.Equal(t, KindConditional, urn.kindOf("lang"))
+	assert.Equal(t, KindExact, urn.kindOf("name"))
+
+	// ForceLowercaseValues lowercases every value, literals included
+	urn, err = NewTaggedUrnBuilder("cap").
+		WithOptions(ParseOptions{ForceLowercaseValues: true}).
+		Tag("title", "Hello World").
+		Tag("name", "/^A/").
+		Build()
+	require.NoError(t, err)
+	assert.True(t, urn.HasTag("title", "hello world"))
+	assert.True(t, urn.HasTag("name", "/^a/"))
+	assert.Equal(t, KindExact, urn.kindOf("name"))
+}
+
+func TestIsCanonical(t *testing.T) {
+	for s, expected := range map[string]bool{
+		"cap:ext=pdf;op=generate": true,
+		`cap:title="Hello World"`: true,
+		"cap:":                    true,
+		"cap:debug=!;ext":         true,
+		"cap:op=generate;ext=pdf": false,
+		"CAP:ext=pdf":             false,
+		"cap:ext=pdf;":            false,
+		"cap:ext=*":               false,
+		`cap:ext="pdf"`:           false,
+		"cap:ext=PDF":             false,
+	} {
+		canonical, err := IsCanonical(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, expected, canonical, s)
+	}
+
+	_, err := IsCanonical("cap:op=a b")
+	require.Error(t, err)
+	assert.Equal(t, ErrorInvalidCharacter, err.(*TaggedUrnError).Code)
+}
+
+func TestConstraints(t *testing.T) {
+	urn, err := NewTaggedUrnFromString(`cap:op=generate;ext;debug=!;draft=?;fmt=@formats;mode=~fast;name=/^img_\d+$/`)
+	require.NoError(t, err)
+
+	assert.Equal(t, []KeyConstraint{
+		{Key: "debug", Kind: KindMustNotHave},
+		{Key: "draft", Kind: KindUnspecified},
+		{Key: "ext", Kind: KindMustHaveAny},
+		{Key: "fmt", Kind: KindEnum, Value: "formats"},
+		{Key: "mode", Kind: KindConditional, Value: "fast"},
+		{Key: "name", Kind: KindRegex, Value: `^img_\d+$`},
+		{Key: "op", Kind: KindExact, Value: "generate"},
+	}, urn.Constraints())
+
+	assert.Empty(t, Empty("cap").Constraints())
+}
+
+func TestForceLowercaseValues(t *testing.T) {
+	opts := ParseOptions{ForceLowercaseValues: true}
+
+	quoted, err := ParseWithOptions(`cap:key="PDF";title="Hello World"`, opts)
+	require.NoError(t, err)
+	assert.True(t, quoted.HasTag("key", "pdf"))
+	assert.Equal(t, `cap:key=pdf;title="hello world"`, quoted.ToString())
+
+	unquoted, err := ParseWithOptions("cap:key=PDF", opts)
+	require.NoError(t, err)
+	keyOnly, err := ParseWithOptions(`cap:key="PDF"`, opts)
+	require.NoError(t, err)
+	assert.True(t, keyOnly.Equals(unquoted))
+
+	// Regex values keep their case
+	regex, err := ParseWithOptions(`cap:name=/^\D+$/`, opts)
+	require.NoError(t, err)
+	assert.True(t, regex.HasTag("name", `/^\D+$/`))
+
+	// Default still preserves quoted case
+	preserved, err := NewTaggedUrnFromString(`cap:key="PDF"`)
+	require.NoError(t, err)
+	assert.True(t, preserved.HasTag("key", "PDF"))
+}
+
+func TestMatchesIgnoring(t *testing.T) {
+	instance, err := NewTaggedUrnFromString("cap:op=generate;ext=pdf;region=eu")
+	require.NoError(t, err)
+	pattern, err := NewTaggedUrnFromString("cap:op=generate;region=us")
+	require.NoError(t, err)
+
+	matches, err := instance.ConformsTo(pattern)
+	require.NoError(t, err)
+	assert.False(t, matches)
+
+	matches, err = instance.MatchesIgnoring(pattern, []string{"Region"})
+	require.NoError(t, err)
+	assert.True(t, matches)
+
+	// Ignoring a key the instance lacks removes the pattern's requirement too
+	required, err := NewTaggedUrnFromString("cap:op=generate;tenant")
+	require.NoError(t, err)
+	matches, err = instance.MatchesIgnoring(required, []string{"tenant"})
+	require.NoError(t, err)
+	assert.True(t, matches)
+
+	// Other keys still apply
+	other, err := NewTaggedUrnFromString("cap:op=extract;region=us")
+	require.NoError(t, err)
+	matches, err = instance.MatchesIgnoring(other, []string{"region"})
+	require.NoError(t, err)
+	assert.False(t, matches)
+
+	media, err := NewTaggedUrnFromString("media:op=generate")
+	require.NoError(t, err)
+	_, err = instance.MatchesIgnoring(media, nil)
+	require.Error(t, err)
+	assert.Equal(t, ErrorPrefixMismatch, err.(*TaggedUrnError).Code)
+
+	_, err = instance.MatchesIgnoring(nil, nil)
+	require.Error(t, err)
+}
+
+func TestBuilderReset(t *testing.T) {
+	builder := NewTaggedUrnBuilder("cap")
+	first, err := builder.Tag("op", "generate").Tag("ext", "pdf").Build()
+	require.NoError(t, err)
+
+	second, err := builder.Reset("Media").Tag("type", "image").Build()
+	require.NoError(t, err)
+	assert.Equal(t, "media:type=image", second.ToString())
+
+	// URNs built before the reset are unaffected
+	assert.Equal(t, "cap:ext=pdf;op=generate", first.ToString())
+	builder.Tag("extra", "x")
+	assert.Equal(t, "media:type=image", second.ToString())
+
+	// Reset clears a recorded error
+	_, err = builder.Reset("cap").Tag("op", "").Build()
+	require.Error(t, err)
+	urn, err := builder.Reset("cap").Tag("op", "extract").Build()
+	require.NoError(t, err)
+	assert.Equal(t, "cap:op=extract", urn.ToString())
+}
+
+func TestBuilderRemoveAndHas(t *testing.T) {
+	builder := NewTaggedUrnBuilder("cap").
+		Tag("op", "generate").
+		Tag("ext", "pdf").
+		SoloTag("debug")
+	assert.True(t, builder.Has("DEBUG"))
+
+	builder.Remove("Debug").Remove("missing")
+	assert.False(t, builder.Has("debug"))
+	assert.True(t, builder.Has("ext"))
+
+	urn, err := builder.Build()
+	require.NoError(t, err)
+	assert.Equal(t, "cap:ext=pdf;op=generate", urn.ToString())
+	_, exists := urn.GetTag("debug")
+	assert.False(t, exists)
+}
+
+func TestRegisterReservedPrefix(t *testing.T) {
+	_, err := NewTaggedUrnFromString("reservedtest:op=generate")
+	require.NoError(t, err)
+
+	RegisterReservedPrefix("ReservedTest")
+	t.Cleanup(func() { UnregisterReservedPrefix("reservedtest") })
+
+	_, err = NewTaggedUrnFromString("RESERVEDTEST:op=generate")
+	require.Error(t, err)
+	assert.Equal(t, ErrorReservedPrefix, err.(*TaggedUrnError).Code)
+
+	_, err = ParseWithOptions("reservedtest:", ParseOptions{})
+	require.Error(t, err)
+	assert.Equal(t, ErrorReservedPrefix, err.(*TaggedUrnError).Code)
+
+	_, err = NewTaggedUrnBuilder("reservedtest").Tag("op", "generate").Build()
+	require.Error(t, err)
+	assert.Equal(t, ErrorReservedPrefix, err.(*TaggedUrnError).Code)
+
+	_, err = Single("reservedtest", "op", "generate")
+	require.Error(t, err)
+	assert.Equal(t, ErrorReservedPrefix, err.(*TaggedUrnError).Code)
+
+	_, err = FromStruct("reservedtest", struct{ Op string }{Op: "generate"})
+	require.Error(t, err)
+	assert.Equal(t, ErrorReservedPrefix, err.(*TaggedUrnError).Code)
+
+	// Other prefixes are unaffected
+	_, err = NewTaggedUrnFromString("cap:op=generate")
+	require.NoError(t, err)
+
+	UnregisterReservedPrefix("ReservedTest")
+	_, err = NewTaggedUrnFromString("reservedtest:op=generate")
+	require.NoError(t, err)
+}
+
+func TestSatisfiesAll(t *testing.T) {
+	matcher := &UrnMatcher{}
+	instance, err := NewTaggedUrnFromString("cap:op=generate;ext=pdf;tenant=acme")
+	require.NoError(t, err)
+
+	var policies []*TaggedUrn
+	for _, s := range []string{"cap:tenant", "cap:ext=png", "cap:debug=!"} {
+		policy, err := NewTaggedUrnFromString(s)
+		require.NoError(t, err)
+		policies = append(policies, policy)
+	}
+
+	ok, failing, err := matcher.SatisfiesAll(instance, policies)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Same(t, policies[1], failing)
+
+	ok, failing, err = matcher.SatisfiesAll(instance, []*TaggedUrn{policies[0], policies[2]})
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Nil(t, failing)
+
+	ok, _, err = matcher.SatisfiesAll(instance, nil)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	media, err := NewTaggedUrnFromString("media:tenant")
+	require.NoError(t, err)
+	_, _, err = matcher.SatisfiesAll(instance, []*TaggedUrn{policies[0], media})
+	require.Error(t, err)
+	assert.Equal(t, ErrorPrefixMismatch, err.(*TaggedUrnError).Code)
+
+	_, _, err = matcher.SatisfiesAll(instance, []*TaggedUrn{nil})
+	require.Error(t, err)
+	_, _, err = matcher.SatisfiesAll(nil, policies)
+	require.Error(t, err)
+}
+
+func TestSpecificityLargeTagCount(t *testing.T) {
+	const count = 50000
+	tags := make(map[string]string, count)
+	for i := 0; i < count; i++ {
+		tags[fmt.Sprintf("k%d", i)] = "v"
+	}
+	urn := NewTaggedUrnFromTags("cap", tags)
+
+	assert.Equal(t, int64(3*count), urn.SpecificityInt64())
+	assert.Equal(t, 3*count, urn.Specificity())
+
+	more, err := urn.IsMoreSpecificThan(urn.WithoutTag("k0"))
+	require.NoError(t, err)
+	assert.True(t, more)
+}
+
+func TestToStringNatural(t *testing.T) {
+	urn, err := NewTaggedUrnFromString("cap:item10=c;item2=b;item1=a;item02=d;name=x;item")
+	require.NoError(t, err)
+
+	assert.Equal(t, "cap:item;item1=a;item02=d;item2=b;item10=c;name=x", urn.ToStringNatural())
+	assert.Equal(t, "cap:item;item02=d;item1=a;item10=c;item2=b;name=x", urn.ToString())
+
+	parsed, err := NewTaggedUrnFromString(urn.ToStringNatural())
+	require.NoError(t, err)
+	assert.True(t, urn.Equals(parsed))
+}
+
+func TestParseLenient(t *testing.T) {
+	urn, errs := ParseLenient("cap:op=generate;123=x;ext=pdf")
+	require.NotNil(t, urn)
+	assert.Equal(t, "cap:ext=pdf;op=generate", urn.ToString())
+	require.Len(t, errs, 1)
+	var urnErr *TaggedUrnError
+	require.True(t, errors.As(errs[0], &urnErr))
+	assert.Equal(t, ErrorNumericKey, urnErr.Code)
+	assert.Contains(t, errs[0].Error(), "123=x")
+
+	urn, errs = ParseLenient(`CAP:title="a;b";op=a b;ext=pdf;ext=png;;bad"q;draft`)
+	require.NotNil(t, urn)
+	assert.Equal(t, `cap:draft;ext=pdf;title="a;b"`, urn.ToString())
+	codes := make([]int, len(errs))
+	for i, err := range errs {
+		require.True(t, errors.As(err, &urnErr))
+		codes[i] = urnErr.Code
+	}
+	assert.Equal(t, []int{ErrorInvalidCharacter, ErrorDuplicateKey, ErrorInvalidCharacter}, codes)
+
+	urn, errs = ParseLenient("cap:op=generate")
+	assert.Equal(t, "cap:op=generate", urn.ToString())
+	assert.Nil(t, errs)
+
+	for _, s := range []string{"no-prefix", ":op=a", " cap:op=a"} {
+		urn, errs = ParseLenient(s)
+		assert.Nil(t, urn, s)
+		assert.Len(t, errs, 1, s)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	base, err := NewTaggedUrnFromString("cap:op=generate;ext=pdf")
+	require.NoError(t, err)
+
+	for s, expected := range map[string]int{
+		"cap:ext=pdf;op=generate":         0,
+		"cap:op=generate;ext=pdf;quality": 1, // add
+		"cap:op=generate":                 1, // remove
+		"cap:op=generate;ext=png":         1, // change value
+		"cap:op=generate;ext":             1, // wildcard differs from exact
+		"cap:op=extract;ext=png;target=x": 3,
+		"cap:":                            2,
+		"media:op=generate;ext=pdf":       PrefixMismatchDistance,
+	} {
+		other, err := NewTaggedUrnFromString(s)
+		require.NoError(t, err)
+		assert.Equal(t, expected, base.Distance(other), s)
+		assert.Equal(t, expected, other.Distance(base), s)
+	}
+}
+
+func TestFindNearest(t *testing.T) {
+	matcher := &UrnMatcher{}
+	var urns []*TaggedUrn
+	for _, s := range []string{
+		"cap:op=extract;ext=pdf;target=text",
+		"cap:op=generate;ext=png",
+		"cap:op=generate;ext=jpg",
+	} {
+		urn, err := NewTaggedUrnFromString(s)
+		require.NoError(t, err)
+		urns = append(urns, urn)
+	}
+
+	request, err := NewTaggedUrnFromString("cap:op=generate;ext=pdf")
+	require.NoError(t, err)
+	best, err := matcher.FindBestMatch(urns, request)
+	require.NoError(t, err)
+	assert.Nil(t, best)
+
+	// png and jpg are both one value change away; jpg sorts first
+	nearest, distance, err := matcher.FindNearest(urns, request)
+	require.NoError(t, err)
+	assert.Same(t, urns[2], nearest)
+	assert.Equal(t, 1, distance)
+
+	far, err := NewTaggedUrnFromString("cap:op=extract;target=text")
+	require.NoError(t, err)
+	nearest, distance, err = matcher.FindNearest(urns, far)
+	require.NoError(t, err)
+	assert.Same(t, urns[0], nearest)
+	assert.Equal(t, 1, distance)
+
+	nearest, distance, err = matcher.FindNearest(nil, request)
+	require.NoError(t, err)
+	assert.Nil(t, nearest)
+	assert.Equal(t, -1, distance)
+
+	media, err := NewTaggedUrnFromString("media:op=generate")
+	require.NoError(t, err)
+	_, _, err = matcher.FindNearest(urns, media)
+	require.Error(t, err)
+	assert.Equal(t, ErrorPrefixMismatch, err.(*TaggedUrnError).Code)
+}
+
+func TestFreeze(t *testing.T) {
+	urn, err := NewTaggedUrnFromString("cap:op=generate;ext=pdf")
+	require.NoError(t, err)
+	assert.False(t, urn.IsFrozen())
+	assert.Same(t, urn, urn.Freeze())
+	assert.True(t, urn.IsFrozen())
+
+	// Mutating returned copies never affects the URN
+	tags := urn.AllTags()
+	tags["op"] = "extract"
+	tags["debug"] = "!"
+	assert.Equal(t, "cap:ext=pdf;op=generate", urn.ToString())
+
+	// Derived URNs are new, unfrozen instances
+	derived := urn.WithTag("quality", "high")
+	assert.False(t, derived.IsFrozen())
+	assert.Equal(t, "cap:ext=pdf;op=generate", urn.ToString())
+
+	err = json.Unmarshal([]byte(`"cap:op=extract"`), urn)
+	require.Error(t, err)
+	assert.Equal(t, ErrorFrozenUrn, err.(*TaggedUrnError).Code)
+	assert.Equal(t, "cap:ext=pdf;op=generate", urn.ToString())
+
+	require.NoError(t, json.Unmarshal([]byte(`"cap:op=extract"`), derived))
+	assert.Equal(t, "cap:op=extract", derived.ToString())
+}
+
+func TestParseWithLookup(t *testing.T) {
+	env := map[string]string{"REGION": "EU-West", "TIER": "gold", "SPACED": "a b"}
+	opts := ParseOptions{Lookup: func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}}
+
+	urn, err := ParseWithOptions("cap:region=${REGION};tier=t-${TIER}-x;zone=${ZONE:-default-zone}", opts)
+	require.NoError(t, err)
+	assert.Equal(t, "cap:region=eu-west;tier=t-gold-x;zone=default-zone", urn.ToString())
+
+	// Quoted references stay literal
+	urn, err = ParseWithOptions(`cap:region="${REGION}"`, opts)
+	require.NoError(t, err)
+	assert.True(t, urn.HasTag("region", "${REGION}"))
+
+	_, err = ParseWithOptions("cap:region=${MISSING}", opts)
+	require.Error(t, err)
+	assert.Equal(t, ErrorUndefinedVariable, err.(*TaggedUrnError).Code)
+
+	for s, code := range map[string]int{
+		"cap:region=${REGION":   ErrorInvalidFormat,
+		"cap:region=${}":        ErrorInvalidFormat,
+		"cap:region=${RE-GION}": ErrorInvalidCharacter,
+		"cap:region=${SPACED}":  ErrorInvalidCharacter,
+	} {
+		_, err = ParseWithOptions(s, opts)
+		require.Error(t, err, s)
+		assert.Equal(t, code, err.(*TaggedUrnError).Code, s)
+	}
+
+	// Without Lookup the syntax is rejected as before
+	_, err = NewTaggedUrnFromString("cap:region=${REGION}")
+	require.Error(t, err)
+	assert.Equal(t, ErrorInvalidCharacter, err.(*TaggedUrnError).Code)
+}
+
+func TestAcceptsTag(t *testing.T) {
+	pattern, err := NewTaggedUrnFromString("cap:ext;op=generate;debug=!;draft=?;mode=~fast;fmt=@acceptstag;name=/^img_\\d+$/")
+	require.NoError(t, err)
+	RegisterEnum("acceptstag", []string{"pdf", "png"})
+
+	assert.False(t, pattern.HasTag("ext", "pdf"))
+	assert.True(t, pattern.AcceptsTag("EXT", "pdf"))
+	assert.True(t, pattern.AcceptsTag("op", "generate"))
+	assert.False(t, pattern.AcceptsTag("op", "extract"))
+	assert.False(t, pattern.AcceptsTag("debug", "true"))
+	assert.True(t, pattern.AcceptsTag("draft", "anything"))
+	assert.True(t, pattern.AcceptsTag("mode", "fast"))
+	assert.False(t, pattern.AcceptsTag("mode", "slow"))
+	assert.True(t, pattern.AcceptsTag("fmt", "png"))
+	assert.False(t, pattern.AcceptsTag("fmt", "gif"))
+	assert.True(t, pattern.AcceptsTag("name", "img_42"))
+	assert.False(t, pattern.AcceptsTag("name", "img_x"))
+	assert.True(t, pattern.AcceptsTag("unconstrained", "x"))
+
+	unknown, err := NewTaggedUrnFromString("cap:fmt=@acceptstag-unregistered")
+	require.NoError(t, err)
+	assert.False(t, unknown.AcceptsTag("fmt", "pdf"))
+}
+
+func TestToStringCompat(t *testing.T) {
+	urn, err := NewTaggedUrnFromString("cap:ext")
+	require.NoError(t, err)
+	assert.Equal(t, "cap:ext=*", urn.ToStringCompat())
+
+	urn, err = NewTaggedUrnFromString(`cap:op=generate;ext;debug=!;draft=?;title="Hi there"`)
+	require.NoError(t, err)
+	compat := urn.ToStringCompat()
+	assert.Equal(t, `cap:debug=!;draft=?;ext=*;op=generate;title="Hi there"`, compat)
+
+	parsed, err := NewTaggedUrnFromString(compat)
+	require.NoError(t, err)
+	assert.True(t, urn.Equals(parsed))
+}
+
+func TestTypedAccessors(t *testing.T) {
+	urn, err := NewTaggedUrnFromString("cap:count=42;neg=-7;debug=1;draft=false;timeout=1h30m;name=abc;any;off=!")
+	require.NoError(t, err)
+
+	n, ok, err := urn.GetInt("Count")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 42, n)
+	n, _, err = urn.GetInt("neg")
+	require.NoError(t, err)
+	assert.Equal(t, -7, n)
+
+	b, ok, err := urn.GetBool("debug")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, b)
+	b, ok, err = urn.GetBool("draft")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, b)
+
+	d, ok, err := urn.GetDuration("timeout")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 90*time.Minute, d)
+
+	// Absent is not an error
+	n, ok, err = urn.GetInt("missing")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Zero(t, n)
+	_, ok, err = urn.GetBool("missing")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	_, ok, err = urn.GetDuration("missing")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// Present but malformed, including special values
+	for _, key := range []string{"name", "any", "off"} {
+		_, ok, err = urn.GetInt(key)
+		assert.True(t, ok, key)
+		require.Error(t, err, key)
+		assert.Equal(t, ErrorInvalidValue, err.(*TaggedUrnError).Code, key)
+		_, _, err = urn.GetBool(key)
+		require.Error(t, err, key)
+		_, _, err = urn.GetDuration(key)
+		require.Error(t, err, key)
+	}
+	_, _, err = urn.GetBool("count")
+	assert.Error(t, err)
+}
+
+func TestMergeTracked(t *testing.T) {
+	var urns []*TaggedUrn
+	for _, s := range []string{
+		"cap:op=generate;ext=png",
+		"cap:quality=high",
+		"cap:ext=pdf;debug=!",
+	} {
+		urn, err := NewTaggedUrnFromString(s)
+		require.NoError(t, err)
+		urns = append(urns, urn)
+	}
+
+	merged, sources, err := MergeTracked(urns...)
+	require.NoError(t, err)
+	assert.Equal(t, "cap:debug=!;ext=pdf;op=generate;quality=high", merged.ToString())
+	assert.Equal(t, map[string]int{"op": 0, "quality": 1, "ext": 2, "debug": 2}, sources)
+
+	chained, err := urns[0].Merge(urns[1])
+	require.NoError(t, err)
+	chained, err = chained.Merge(urns[2])
+	require.NoError(t, err)
+	assert.True(t, merged.Equals(chained))
+
+	_, _, err = MergeTracked()
+	require.Error(t, err)
+
+	media, err := NewTaggedUrnFromString("media:ext=pdf")
+	require.NoError(t, err)
+	_, _, err = MergeTracked(urns[0], media)
+	require.Error(t, err)
+	assert.Equal(t, ErrorPrefixMismatch, err.(*TaggedUrnError).Code)
+
+	_, _, err = MergeTracked(urns[0], nil)
+	require.Error(t, err)
+}
+
+func TestNonASCIIKeys(t *testing.T) {
+	urn, err := NewTaggedUrnFromString(`cap:op=generate;title="Café";naïve=x;ext=pdf`)
+	require.NoError(t, err)
+	assert.False(t, urn.IsASCII())
+	assert.Equal(t, []string{"naïve", "title"}, urn.NonASCIIKeys())
+
+	ascii, err := NewTaggedUrnFromString(`cap:op=generate;title="Hello World"`)
+	require.NoError(t, err)
+	assert.True(t, ascii.IsASCII())
+	assert.Empty(t, ascii.NonASCIIKeys())
+
+	// A non-ASCII prefix fails IsASCII but has no key to list
+	prefixed := NewTaggedUrnFromTags("café", map[string]string{"op": "generate"})
+	assert.False(t, prefixed.IsASCII())
+	assert.Empty(t, prefixed.NonASCIIKeys())
+}
+
+func TestFindBestMatchContext(t *testing.T) {
+	matcher := &UrnMatcher{}
+	urns := make([]*TaggedUrn, 10000)
+	for i := range urns {
+		urn, err := NewTaggedUrnFromString(fmt.Sprintf("cap:op=generate;id=%d", i))
+		require.NoError(t, err)
+		urns[i] = urn
+	}
+	request, err := NewTaggedUrnFromString("cap:op=generate;id=9999")
+	require.NoError(t, err)
+
+	best, err := matcher.FindBestMatchContext(context.Background(), urns, request)
+	require.NoError(t, err)
+	assert.Same(t, urns[9999], best)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	best, err = matcher.FindBestMatchContext(ctx, urns, request)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, best)
+
+	ctx, cancel = context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	_, err = matcher.FindBestMatchContext(ctx, urns, request)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestComplementTag(t *testing.T) {
+	urn, err := NewTaggedUrnFromString("cap:op=generate;ext;debug=!;draft=?;mode=~fast;fmt=@formats")
+	require.NoError(t, err)
+
+	for key, expected := range map[string]string{
+		"ext":   "!",
+		"debug": "*",
+		"op":    "!",
+		"fmt":   "!",
+	} {
+		complement, err := urn.ComplementTag(strings.ToUpper(key))
+		require.NoError(t, err, key)
+		value, _ := complement.GetTag(key)
+		assert.Equal(t, expected, value, key)
+		assert.Len(t, complement.AllTags(), len(urn.AllTags()), key)
+	}
+
+	// The best-effort complement never accepts what the original accepts
+	complement, err := urn.ComplementTag("op")
+	require.NoError(t, err)
+	assert.True(t, complement.HasTag("op", "!"))
+	instance, err := NewTaggedUrnFromString("cap:op=generate")
+	require.NoError(t, err)
+	accepts, err := NewTaggedUrnFromTags("cap", map[string]string{"op": "!"}).Accepts(instance)
+	require.NoError(t, err)
+	assert.False(t, accepts)
+
+	for _, key := range []string{"draft", "mode", "missing"} {
+		_, err := urn.ComplementTag(key)
+		require.Error(t, err, key)
+		assert.Equal(t, ErrorNoComplement, err.(*TaggedUrnError).Code, key)
+	}
+}
+
+func TestFindBestMatchWeighted(t *testing.T) {
+	matcher := &UrnMatcher{}
+	var urns []*TaggedUrn
+	for _, s := range []string{
+		"cap:op=generate",
+		"cap:op=generate;host=a",
+		"cap:op=generate;host=b",
+		"cap:op=generate;host=c",
+		"cap:op=extract;host=d",
+	} {
+		urn, err := NewTaggedUrnFromString(s)
+		require.NoError(t, err)
+		urns = append(urns, urn)
+	}
+	request, err := NewTaggedUrnFromString("cap:op=generate")
+	require.NoError(t, err)
+
+	// Only the equally specific host=a/b/c candidates are ever picked
+	counts := map[string]int{}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 3000; i++ {
+		best, err := matcher.FindBestMatchWeighted(urns, request, rng, nil)
+		require.NoError(t, err)
+		host, _ := best.GetTag("host")
+		counts[host]++
+	}
+	assert.Len(t, counts, 3)
+	for _, host := range []string{"a", "b", "c"} {
+		assert.InDelta(t, 1000, counts[host], 150, host)
+	}
+
+	// Weights skew the choice; zero weight is never picked
+	weight := func(u *TaggedUrn) int {
+		switch host, _ := u.GetTag("host"); host {
+		case "a":
+			return 3
+		case "b":
+			return 1
+		default:
+			return 0
+		}
+	}
+	counts = map[string]int{}
+	for i := 0; i < 4000; i++ {
+		best, err := matcher.FindBestMatchWeighted(urns, request, rng, weight)
+		require.NoError(t, err)
+		host, _ := best.GetTag("host")
+		counts[host]++
+	}
+	assert.Zero(t, counts["c"])
+	assert.InDelta(t, 3000, counts["a"], 200)
+
+	// A seeded rng is deterministic
+	first, err := matcher.FindBestMatchWeighted(urns, request, rand.New(rand.NewSource(42)), nil)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		again, err := matcher.FindBestMatchWeighted(urns, request, rand.New(rand.NewSource(42)), nil)
+		require.NoError(t, err)
+		assert.Same(t, first, again)
+	}
+
+	none, err := NewTaggedUrnFromString("cap:op=convert")
+	require.NoError(t, err)
+	best, err := matcher.FindBestMatchWeighted(urns, none, rng, nil)
+	require.NoError(t, err)
+	assert.Nil(t, best)
+}
+
+func TestPartition(t *testing.T) {
+	urn, err := NewTaggedUrnFromString(`cap:op=generate;ext;size=@partition-sizes;sku=/^a\d$/;debug=!;target=?;fit=~slim`)
+	require.NoError(t, err)
+
+	required, forbidden, optional := urn.Partition()
+	assert.Equal(t, `cap:ext;op=generate;size=@partition-sizes;sku=/^a\d$/`, required.ToString())
+	assert.Equal(t, "cap:debug=!", forbidden.ToString())
+	assert.Equal(t, "cap:fit=~slim;target=?", optional.ToString())
+
+	// Every tag lands in exactly one partition, all under the same prefix
+	assert.Equal(t, len(urn.AllTags()), len(required.AllTags())+len(forbidden.AllTags())+len(optional.AllTags()))
+	assert.Equal(t, "cap", optional.GetPrefix())
+}
+
+func TestMatchesExactlyOne(t *testing.T) {
+	pattern, err := NewTaggedUrnFromString("cap:op=convert")
+	require.NoError(t, err)
+	formats := []string{"pdf", "DOCX", "txt", "pdf"}
+
+	one, err := NewTaggedUrnFromString("cap:op=convert;docx;pdf=!;txt=?")
+	require.NoError(t, err)
+	ok, err := pattern.MatchesExactlyOne(one, formats)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	two, err := NewTaggedUrnFromString("cap:op=convert;pdf;txt=yes")
+	require.NoError(t, err)
+	ok, err = pattern.MatchesExactlyOne(two, formats)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	none, err := NewTaggedUrnFromString("cap:op=convert")
+	require.NoError(t, err)
+	ok, err = pattern.MatchesExactlyOne(none, formats)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// The per-tag constraints still apply
+	other, err := NewTaggedUrnFromString("cap:op=extract;pdf")
+	require.NoError(t, err)
+	ok, err = pattern.MatchesExactlyOne(other, formats)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,68 @@
+package taggedurn
+
+import "fmt"
+
+// TokenKind classifies a token produced by Tokenize
+type TokenKind int
+
+const (
+	// TokenPrefix is the prefix before the first ':'
+	TokenPrefix TokenKind = iota
+	// TokenKey is a tag key
+	TokenKey
+	// TokenEquals is the '=' between a key and its value
+	TokenEquals
+	// TokenValue is a tag value; for a quoted value it excludes the quotes
+	TokenValue
+	// TokenSemicolon is a ';' separator
+	TokenSemicolon
+	// TokenQuote is an opening or closing '"' around a quoted value
+	TokenQuote
+)
+
+// String returns the name of the token kind
+func (k TokenKind) String() string {
+	switch k {
+	case TokenPrefix:
+		return "prefix"
+	case TokenKey:
+		return "key"
+	case TokenEquals:
+		return "equals"
+	case TokenValue:
+		return "value"
+	case TokenSemicolon:
+		return "semicolon"
+	case TokenQuote:
+		return "quote"
+	default:
+		return fmt.Sprintf("TokenKind(%d)", int(k))
+	}
+}
+
+// Token is a lexical element of a tagged URN string
+// Value is the source text exactly as written (not lowercased, escapes kept)
+// and Start/End are the rune offsets of that text in the input, End
+// exclusive. The ':' after the prefix is implied and has no token.
+type Token struct {
+	Kind  TokenKind
+	Value string
+	Start int
+	End   int
+}
+
+// Tokenize returns the tokens of s in source order, for tooling such as
+// formatters and highlighters
+// It runs the same state machine as NewTaggedUrnFromString, so it accepts
+// exactly the same inputs and returns the same errors; tokens are nil when
+// parsing fails.
+func Tokenize(s string) ([]Token, error) {
+	var tokens []Token
+	_, err := parse(s, ParseOptions{}, nil, func(t Token) {
+		tokens = append(tokens, t)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
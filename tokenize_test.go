@@ -0,0 +1,64 @@
+package taggedurn
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenize(t *testing.T) {
+	tokens, err := Tokenize(`Cap:op=Generate;ext;name="a \"b\"";`)
+	require.NoError(t, err)
+
+	assert.Equal(t, []Token{
+		{Kind: TokenPrefix, Value: "Cap", Start: 0, End: 3},
+		{Kind: TokenKey, Value: "op", Start: 4, End: 6},
+		{Kind: TokenEquals, Value: "=", Start: 6, End: 7},
+		{Kind: TokenValue, Value: "Generate", Start: 7, End: 15},
+		{Kind: TokenSemicolon, Value: ";", Start: 15, End: 16},
+		{Kind: TokenKey, Value: "ext", Start: 16, End: 19},
+		{Kind: TokenSemicolon, Value: ";", Start: 19, End: 20},
+		{Kind: TokenKey, Value: "name", Start: 20, End: 24},
+		{Kind: TokenEquals, Value: "=", Start: 24, End: 25},
+		{Kind: TokenQuote, Value: `"`, Start: 25, End: 26},
+		{Kind: TokenValue, Value: `a \"b\"`, Start: 26, End: 33},
+		{Kind: TokenQuote, Value: `"`, Start: 33, End: 34},
+		{Kind: TokenSemicolon, Value: ";", Start: 34, End: 35},
+	}, tokens)
+}
+
+func TestTokenizeEndsAndRegex(t *testing.T) {
+	tokens, err := Tokenize(`cap:name=/^img_\d+$/;ext`)
+	require.NoError(t, err)
+	kinds := make([]TokenKind, len(tokens))
+	for i, tok := range tokens {
+		kinds[i] = tok.Kind
+	}
+	assert.Equal(t, []TokenKind{TokenPrefix, TokenKey, TokenEquals, TokenValue, TokenSemicolon, TokenKey}, kinds)
+	assert.Equal(t, `/^img_\d+$/`, tokens[3].Value)
+	assert.Equal(t, "ext", tokens[5].Value)
+
+	tokens, err = Tokenize("cap:")
+	require.NoError(t, err)
+	assert.Equal(t, []Token{{Kind: TokenPrefix, Value: "cap", Start: 0, End: 3}}, tokens)
+}
+
+func TestTokenizeOffsetsAreRunes(t *testing.T) {
+	s := `cap:name="héllo";ext=pdf`
+	tokens, err := Tokenize(s)
+	require.NoError(t, err)
+	runes := []rune(s)
+	for _, tok := range tokens {
+		assert.Equal(t, tok.Value, string(runes[tok.Start:tok.End]))
+	}
+	assert.Equal(t, "pdf", tokens[len(tokens)-1].Value)
+}
+
+func TestTokenizeErrors(t *testing.T) {
+	for _, s := range []string{"cap:op=\"open", "cap:a=1;a=2", "cap", "cap:op=a b"} {
+		tokens, err := Tokenize(s)
+		assert.Error(t, err, s)
+		assert.Nil(t, tokens, s)
+	}
+}
@@ -0,0 +1,71 @@
+package taggedurn
+
+import "sort"
+
+// urnMapEntry pairs a stored URN with its value
+type urnMapEntry[V any] struct {
+	urn   *TaggedUrn
+	value V
+}
+
+// UrnMap is a map keyed by tagged URN value rather than pointer identity
+// URNs that are Equals share one slot, since entries are keyed by canonical
+// string. The zero value is not usable; create one with NewUrnMap. Not safe
+// for concurrent use.
+type UrnMap[V any] struct {
+	entries map[string]urnMapEntry[V]
+}
+
+// NewUrnMap creates an empty UrnMap
+func NewUrnMap[V any]() *UrnMap[V] {
+	return &UrnMap[V]{entries: make(map[string]urnMapEntry[V])}
+}
+
+// Set stores value under urn, replacing the value of any equal URN
+// A nil urn is ignored.
+func (m *UrnMap[V]) Set(urn *TaggedUrn, value V) {
+	if urn == nil {
+		return
+	}
+	m.entries[urn.canonical()] = urnMapEntry[V]{urn: urn, value: value}
+}
+
+// Get returns the value stored under a URN equal to urn
+func (m *UrnMap[V]) Get(urn *TaggedUrn) (V, bool) {
+	if urn == nil {
+		var zero V
+		return zero, false
+	}
+	entry, exists := m.entries[urn.canonical()]
+	return entry.value, exists
+}
+
+// Delete removes the entry for a URN equal to urn, if any
+func (m *UrnMap[V]) Delete(urn *TaggedUrn) {
+	if urn == nil {
+		return
+	}
+	delete(m.entries, urn.canonical())
+}
+
+// Len returns the number of entries
+func (m *UrnMap[V]) Len() int {
+	return len(m.entries)
+}
+
+// Range calls fn for each entry in canonical string order until fn returns
+// false. The URN passed to fn is the one most recently given to Set for that
+// slot. fn must not modify the map.
+func (m *UrnMap[V]) Range(fn func(urn *TaggedUrn, value V) bool) {
+	keys := make([]string, 0, len(m.entries))
+	for key := range m.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		entry := m.entries[key]
+		if !fn(entry.urn, entry.value) {
+			return
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package taggedurn
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUrnMapEqualUrnsShareSlot(t *testing.T) {
+	m := NewUrnMap[int]()
+
+	a, err := NewTaggedUrnFromString("cap:op=generate;ext=pdf")
+	require.NoError(t, err)
+	b, err := NewTaggedUrnFromString("CAP:ext=pdf;op=generate")
+	require.NoError(t, err)
+	require.True(t, a.Equals(b))
+	require.NotSame(t, a, b)
+
+	m.Set(a, 1)
+	value, ok := m.Get(b)
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+
+	m.Set(b, 2)
+	assert.Equal(t, 1, m.Len())
+	value, ok = m.Get(a)
+	assert.True(t, ok)
+	assert.Equal(t, 2, value)
+
+	m.Delete(b)
+	assert.Equal(t, 0, m.Len())
+	_, ok = m.Get(a)
+	assert.False(t, ok)
+}
+
+func TestUrnMapRange(t *testing.T) {
+	m := NewUrnMap[string]()
+	for _, s := range []string{"cap:op=b", "cap:op=a", "media:pdf", "cap:op=c"} {
+		urn, err := NewTaggedUrnFromString(s)
+		require.NoError(t, err)
+		m.Set(urn, s)
+	}
+	m.Set(nil, "ignored")
+	_, ok := m.Get(nil)
+	assert.False(t, ok)
+
+	var visited []string
+	m.Range(func(urn *TaggedUrn, value string) bool {
+		assert.Equal(t, value, urn.ToString())
+		visited = append(visited, value)
+		return true
+	})
+	assert.Equal(t, []string{"cap:op=a", "cap:op=b", "cap:op=c", "media:pdf"}, visited)
+
+	count := 0
+	m.Range(func(*TaggedUrn, string) bool {
+		count++
+		return count < 2
+	})
+	assert.Equal(t, 2, count)
+}
@@ -0,0 +1,144 @@
+package taggedurn
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// UrnSet is a set of tagged URNs with value semantics
+// Membership is by Equals (keyed internally by canonical string), so URNs
+// with different prefixes can share a set. The zero value is an empty set
+// ready to use. Not safe for concurrent use.
+type UrnSet struct {
+	entries map[string]*TaggedUrn
+}
+
+// NewUrnSet creates a set holding the given URNs
+func NewUrnSet(urns ...*TaggedUrn) *UrnSet {
+	s := &UrnSet{}
+	for _, urn := range urns {
+		s.Add(urn)
+	}
+	return s
+}
+
+// Add inserts urn; adding a URN equal to a member has no effect
+// A nil urn is ignored.
+func (s *UrnSet) Add(urn *TaggedUrn) {
+	if urn == nil {
+		return
+	}
+	if s.entries == nil {
+		s.entries = make(map[string]*TaggedUrn)
+	}
+	key := urn.canonical()
+	if _, exists := s.entries[key]; !exists {
+		s.entries[key] = urn
+	}
+}
+
+// Contains checks if a URN equal to urn is a member
+func (s *UrnSet) Contains(urn *TaggedUrn) bool {
+	if urn == nil {
+		return false
+	}
+	_, exists := s.entries[urn.canonical()]
+	return exists
+}
+
+// Len returns the number of members
+func (s *UrnSet) Len() int {
+	return len(s.entries)
+}
+
+// members returns the set's entries; a nil set has none
+func (s *UrnSet) members() map[string]*TaggedUrn {
+	if s == nil {
+		return nil
+	}
+	return s.entries
+}
+
+// Union returns a new set with the members of either set
+// A nil other is treated as an empty set.
+func (s *UrnSet) Union(other *UrnSet) *UrnSet {
+	result := &UrnSet{}
+	for _, urn := range s.entries {
+		result.Add(urn)
+	}
+	for _, urn := range other.members() {
+		result.Add(urn)
+	}
+	return result
+}
+
+// Intersection returns a new set with the members of both sets
+// A nil other is treated as an empty set.
+func (s *UrnSet) Intersection(other *UrnSet) *UrnSet {
+	result := &UrnSet{}
+	for key, urn := range s.entries {
+		if _, exists := other.members()[key]; exists {
+			result.Add(urn)
+		}
+	}
+	return result
+}
+
+// Difference returns a new set with the members of s that are not in other
+// A nil other is treated as an empty set.
+func (s *UrnSet) Difference(other *UrnSet) *UrnSet {
+	result := &UrnSet{}
+	for key, urn := range s.entries {
+		if _, exists := other.members()[key]; !exists {
+			result.Add(urn)
+		}
+	}
+	return result
+}
+
+// Slice returns the members sorted by Compare
+func (s *UrnSet) Slice() []*TaggedUrn {
+	urns := make([]*TaggedUrn, 0, len(s.entries))
+	for _, urn := range s.entries {
+		urns = append(urns, urn)
+	}
+	sort.Slice(urns, func(i, j int) bool {
+		return urns[i].Compare(urns[j]) < 0
+	})
+	return urns
+}
+
+// SetHash returns a hex SHA256 digest identifying the set of urns
+// The digest covers the sorted, de-duplicated canonical forms, so it does
+// not depend on order or repeats and changes whenever a member is added,
+// removed or altered; two catalogs with equal digests hold the same URNs.
+// Each canonical form is length-prefixed, so no two sets share an encoding.
+// Nil entries are ignored.
+func SetHash(urns []*TaggedUrn) string {
+	forms := make([]string, 0, len(urns))
+	for _, urn := range urns {
+		if urn != nil {
+			forms = append(forms, urn.canonical())
+		}
+	}
+	sort.Strings(forms)
+
+	h := sha256.New()
+	var length [8]byte
+	for i, form := range forms {
+		if i > 0 && form == forms[i-1] {
+			continue
+		}
+		binary.BigEndian.PutUint64(length[:], uint64(len(form)))
+		h.Write(length[:])
+		h.Write([]byte(form))
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// Hash returns SetHash of the members
+func (s *UrnSet) Hash() string {
+	return SetHash(s.Slice())
+}
@@ -0,0 +1,110 @@
+package taggedurn
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func urnSetOf(t *testing.T, strs ...string) *UrnSet {
+	t.Helper()
+	s := &UrnSet{}
+	for _, str := range strs {
+		urn, err := NewTaggedUrnFromString(str)
+		require.NoError(t, err)
+		s.Add(urn)
+	}
+	return s
+}
+
+func urnSetStrings(s *UrnSet) []string {
+	var out []string
+	for _, urn := range s.Slice() {
+		out = append(out, urn.ToString())
+	}
+	return out
+}
+
+func TestUrnSetMembership(t *testing.T) {
+	s := urnSetOf(t, "cap:op=generate;ext=pdf", "cap:ext=pdf;op=generate", "CAP:op=generate;ext=pdf")
+	assert.Equal(t, 1, s.Len())
+
+	equal, err := NewTaggedUrnFromString("cap:ext=pdf;op=generate")
+	require.NoError(t, err)
+	assert.True(t, s.Contains(equal))
+	assert.False(t, s.Contains(equal.WithTag("x", "1")))
+	assert.False(t, s.Contains(nil))
+
+	var zero UrnSet
+	assert.Equal(t, 0, zero.Len())
+	assert.False(t, zero.Contains(equal))
+	assert.Empty(t, zero.Slice())
+}
+
+func TestUrnSetAlgebra(t *testing.T) {
+	a := urnSetOf(t, "cap:op=a", "cap:op=b", "cap:op=c")
+	b := urnSetOf(t, "cap:op=b", "cap:op=c", "cap:op=d")
+
+	assert.Equal(t, []string{"cap:op=a", "cap:op=b", "cap:op=c", "cap:op=d"}, urnSetStrings(a.Union(b)))
+	assert.Equal(t, []string{"cap:op=b", "cap:op=c"}, urnSetStrings(a.Intersection(b)))
+	assert.Equal(t, []string{"cap:op=a"}, urnSetStrings(a.Difference(b)))
+	assert.Equal(t, []string{"cap:op=d"}, urnSetStrings(b.Difference(a)))
+	assert.Empty(t, urnSetStrings(a.Difference(a)))
+
+	// Operands are left untouched
+	assert.Equal(t, 3, a.Len())
+	assert.Equal(t, 3, b.Len())
+
+	// A nil operand is an empty set
+	assert.Equal(t, urnSetStrings(a), urnSetStrings(a.Union(nil)))
+	assert.Empty(t, urnSetStrings(a.Intersection(nil)))
+	assert.Equal(t, urnSetStrings(a), urnSetStrings(a.Difference(nil)))
+}
+
+func TestUrnSetMixedPrefixes(t *testing.T) {
+	s := urnSetOf(t, "media:pdf", "cap:ext=pdf", "cap2:ext=pdf", "cap:", "media:ext=pdf")
+	assert.Equal(t, 5, s.Len())
+
+	// Slice orders by prefix first, then by canonical tags
+	assert.Equal(t, []string{"cap:", "cap:ext=pdf", "cap2:ext=pdf", "media:ext=pdf", "media:pdf"}, urnSetStrings(s))
+
+	other := urnSetOf(t, "media:pdf", "cap2:ext=pdf")
+	assert.Equal(t, []string{"cap2:ext=pdf", "media:pdf"}, urnSetStrings(s.Intersection(other)))
+}
+
+func TestCompare(t *testing.T) {
+	a, _ := NewTaggedUrnFromString("cap:ext=pdf")
+	b, _ := NewTaggedUrnFromString("cap:ext=png")
+	c, _ := NewTaggedUrnFromString("cap2:ext=aaa")
+	same, _ := NewTaggedUrnFromString("CAP:ext=pdf")
+
+	assert.Equal(t, -1, a.Compare(b))
+	assert.Equal(t, 1, b.Compare(a))
+	assert.Equal(t, 0, a.Compare(same))
+	assert.Equal(t, -1, b.Compare(c))
+}
+
+func TestSetHash(t *testing.T) {
+	parse := func(strs ...string) []*TaggedUrn {
+		urns := make([]*TaggedUrn, len(strs))
+		for i, s := range strs {
+			urn, err := NewTaggedUrnFromString(s)
+			require.NoError(t, err)
+			urns[i] = urn
+		}
+		return urns
+	}
+
+	base := SetHash(parse("cap:op=generate;ext=pdf", "cap:op=extract", "media:type=image"))
+	assert.Len(t, base, 64)
+	assert.Equal(t, base, SetHash(parse("media:type=image", "CAP:ext=pdf;op=generate", "cap:op=extract")))
+	assert.Equal(t, base, SetHash(parse("cap:op=extract", "cap:op=generate;ext=pdf", "cap:op=extract", "media:type=image")))
+
+	assert.NotEqual(t, base, SetHash(parse("cap:op=generate;ext=png", "cap:op=extract", "media:type=image")))
+	assert.NotEqual(t, base, SetHash(parse("cap:op=generate;ext=pdf", "cap:op=extract")))
+	assert.NotEqual(t, base, SetHash(parse("cap:op=generate;ext=pdf", "cap:op=extract", "media:type=image", "cap:")))
+
+	assert.Equal(t, SetHash(nil), SetHash([]*TaggedUrn{}))
+	assert.Equal(t, base, NewUrnSet(parse("cap:op=extract", "media:type=image", "cap:ext=pdf;op=generate")...).Hash())
+}
@@ -0,0 +1,34 @@
+package taggedurn
+
+// Warning codes reported by ParseWithWarnings
+const (
+	WarningValuelessTag      = 1
+	WarningLowercasedValue   = 2
+	WarningLowercasedKey     = 3
+	WarningLowercasedPrefix  = 4
+	WarningTrailingSemicolon = 5
+)
+
+// Warning describes a non-fatal normalization applied while parsing
+// Position is the rune offset in the input where the affected key, value
+// or character starts.
+type Warning struct {
+	Code     int
+	Message  string
+	Position int
+}
+
+// ParseWithWarnings parses like NewTaggedUrnFromString and also reports how
+// the input was normalized: value-less tags that became "*", keys, values or
+// a prefix that were lowercased, and a redundant trailing ';'. Warnings are
+// advisory; they are nil when parsing fails.
+func ParseWithWarnings(s string) (*TaggedUrn, []Warning, error) {
+	var warnings []Warning
+	urn, err := parse(s, ParseOptions{}, func(w Warning) {
+		warnings = append(warnings, w)
+	}, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return urn, warnings, nil
+}
@@ -0,0 +1,47 @@
+package taggedurn
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseWithWarnings(t *testing.T) {
+	urn, warnings, err := ParseWithWarnings(`cap:op=Generate;ext;name="Keep"`)
+	require.NoError(t, err)
+	assert.Equal(t, `cap:ext;name="Keep";op=generate`, urn.ToString())
+
+	require.Len(t, warnings, 2)
+	assert.Equal(t, WarningLowercasedValue, warnings[0].Code)
+	assert.Equal(t, 7, warnings[0].Position)
+	assert.Contains(t, warnings[0].Message, "'op'")
+	assert.Equal(t, WarningValuelessTag, warnings[1].Code)
+	assert.Equal(t, 16, warnings[1].Position)
+	assert.Contains(t, warnings[1].Message, "'ext'")
+}
+
+func TestParseWithWarningsNormalizations(t *testing.T) {
+	_, warnings, err := ParseWithWarnings("CAP:Op=generate;draft;")
+	require.NoError(t, err)
+
+	codes := make([]int, len(warnings))
+	for i, w := range warnings {
+		codes[i] = w.Code
+	}
+	assert.Equal(t, []int{WarningLowercasedPrefix, WarningLowercasedKey, WarningValuelessTag, WarningTrailingSemicolon}, codes)
+	assert.Equal(t, 21, warnings[3].Position)
+
+	_, warnings, err = ParseWithWarnings("cap:op=generate")
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+
+	_, warnings, err = ParseWithWarnings("cap:;")
+	require.NoError(t, err)
+	require.Len(t, warnings, 1)
+	assert.Equal(t, WarningTrailingSemicolon, warnings[0].Code)
+
+	_, warnings, err = ParseWithWarnings("cap:Op=")
+	assert.Error(t, err)
+	assert.Nil(t, warnings)
+}